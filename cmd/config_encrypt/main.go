@@ -0,0 +1,72 @@
+// config_encrypt 生成配置文件中 ENC(...) 形式的加密值, 或解密已有的加密值
+//
+// 密钥来源优先级: -key 参数 > -keyFile 参数 > 环境变量 CONFIG_ENCRYPT_KEY
+//
+//	go run ./cmd/config_encrypt -keyFile /path/to/key 'root:123456@tcp(localhost:3306)/demo'
+//	echo -n 'secret' | go run ./cmd/config_encrypt
+//	go run ./cmd/config_encrypt -d 'ENC(...)'
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/config"
+)
+
+func main() {
+	key := flag.String("key", "", "aes key, length must be 16, 24 or 32")
+	keyFile := flag.String("keyFile", "", "file which contains the aes key")
+	decrypt := flag.Bool("d", false, "decrypt ENC(...) values instead of encrypt")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-key key | -keyFile file] [-d] [value ...]\n", os.Args[0])
+		_, _ = fmt.Fprintln(flag.CommandLine.Output(), "values are read from stdin when no value is given")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var provider config.KeyProvider = config.EnvKeyProvider{}
+	switch {
+	case len(*key) != 0:
+		provider = config.KeyProviderFunc(func() ([]byte, error) { return []byte(*key), nil })
+	case len(*keyFile) != 0:
+		provider = config.FileKeyProvider{Path: *keyFile}
+	}
+	k, err := provider.Key()
+	if err != nil {
+		exit(err)
+	}
+
+	values := flag.Args()
+	if len(values) == 0 {
+		content, err := ioutil.ReadAll(bufio.NewReader(os.Stdin))
+		if err != nil {
+			exit(err)
+		}
+		values = []string{strings.TrimRight(string(content), "\r\n")}
+	}
+
+	for _, value := range values {
+		var result string
+		if *decrypt {
+			result, err = config.Decrypt(value, k)
+		} else {
+			result, err = config.Encrypt([]byte(value), k)
+		}
+		if err != nil {
+			exit(err)
+		}
+		fmt.Println(result)
+	}
+}
+
+func exit(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "config_encrypt:", err.Error())
+	os.Exit(1)
+}
@@ -2,9 +2,13 @@ package config
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io/ioutil"
 	"net"
 	"strconv"
+	"sync"
+	"time"
 )
 
 import (
@@ -12,7 +16,6 @@ import (
 )
 
 import (
-	"github.com/BurntSushi/toml"
 	"github.com/hashicorp/consul/api"
 	"github.com/nacos-group/nacos-sdk-go/v2/clients"
 	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
@@ -20,8 +23,11 @@ import (
 	"github.com/nacos-group/nacos-sdk-go/v2/vo"
 )
 
+// 各配置源的 Format 为空时, 按文件扩展名、dataId 或 serviceId 的后缀选择解码格式, 详见 GetDecoder
+
 type FileConfig struct {
-	Path string `json:"path"`
+	Path   string `json:"path"`
+	Format string `json:"format"`
 }
 
 type NacosConfig struct {
@@ -31,6 +37,18 @@ type NacosConfig struct {
 	Namespace string `json:"namespace"`
 	DataId    string `json:"dataId"`
 	Group     string `json:"group"`
+	Format    string `json:"format"`
+	// SnapshotDir 本地快照目录, 为空时使用 DefaultSnapshotDir
+	SnapshotDir string `json:"snapshotDir"`
+	// DisableSnapshot 不保存本地快照, nacos 不可用时也不会使用快照启动
+	DisableSnapshot bool `json:"disableSnapshot"`
+	// HistoryLimit Publish 保留的历史版本数, 为 0 时使用 DefaultHistoryLimit
+	HistoryLimit int `json:"historyLimit"`
+
+	snapshot
+	mu       sync.Mutex
+	reloader *Reloader
+	lastData []byte
 }
 
 type ConsulConfig struct {
@@ -39,29 +57,187 @@ type ConsulConfig struct {
 	Addr      string `json:"addr"`
 	Namespace string `json:"namespace"`
 	ServiceId string `json:"serviceId"`
+	Format    string `json:"format"`
+	// SnapshotDir 本地快照目录, 为空时使用 DefaultSnapshotDir
+	SnapshotDir string `json:"snapshotDir"`
+	// DisableSnapshot 不保存本地快照, consul 不可用时也不会使用快照启动
+	DisableSnapshot bool `json:"disableSnapshot"`
+	// HistoryLimit Publish 保留的历史版本数, 为 0 时使用 DefaultHistoryLimit
+	HistoryLimit int `json:"historyLimit"`
+
+	snapshot
+	mu          sync.Mutex
+	reloader    *Reloader
+	lastIndex   uint64
+	lastData    []byte
+	modifyIndex uint64
+	listener    listener
 }
 
 var (
-	ErrAddrIsEmpty    = errors.New("config center addr is empty")
-	ErrContentIsEmpty = errors.New("config content is empty")
+	ErrAddrIsEmpty      = errors.New("config center addr is empty")
+	ErrContentIsEmpty   = errors.New("config content is empty")
+	ErrAlreadyListening = errors.New("config is already being listened")
+)
+
+var (
+	// consulWatchWaitTime consul 阻塞查询的最长等待时间
+	consulWatchWaitTime = time.Minute * 5
+	// retryMinBackoff, retryMaxBackoff 配置中心查询出错后的重试间隔, 每次失败翻倍
+	retryMinBackoff = time.Second
+	retryMaxBackoff = time.Second * 30
 )
 
 // LoadFileConfig 引导 file 配置数据给 conf
 func (c *FileConfig) LoadFileConfig(conf interface{}) (err error) {
-	if _, err = toml.DecodeFile(c.Path, conf); err != nil {
+	decoder, err := GetDecoder(c.Format, c.Path)
+	if err != nil {
+		logger.Errorf("file, unknown config format:%s of local file:%s", c.Format, c.Path)
+		return err
+	}
+
+	content, err := c.read()
+	if err != nil {
+		return err
+	}
+
+	if err = decode(decoder, content, conf); err != nil {
 		logger.Errorf("file, failed to decode content from local file:%s, err:%s", c.Path, err.Error())
 		return err
 	}
+
+	if err = Validate(conf); err != nil {
+		logger.Errorf("file, %s, local file:%s", err.Error(), c.Path)
+		return err
+	}
 	logger.Infof("file, load config successful from local file:%s", c.Path)
 	return nil
 }
 
+// read 读取 file 配置内容
+func (c *FileConfig) read() (content []byte, err error) {
+	if content, err = ioutil.ReadFile(c.Path); err != nil {
+		logger.Errorf("file, failed to read content from local file:%s, err:%s", c.Path, err.Error())
+		return nil, err
+	}
+	return content, nil
+}
+
 // LoadNacosConfig 引导 nacos 配置数据给 conf
 func (c *NacosConfig) LoadNacosConfig(conf interface{}) (err error) {
+	decoder, err := GetDecoder(c.Format, c.DataId)
+	if err != nil {
+		logger.Errorf("nacos, unknown config format:%s of dataId:%s", c.Format, c.DataId)
+		return err
+	}
+
+	content, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	if err = decode(decoder, content, conf); err != nil {
+		logger.Errorf("nacos, failed to decode content from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+		return err
+	}
+
+	if err = Validate(conf); err != nil {
+		logger.Errorf("nacos, %s, addr:%s, namespaceId:%s, dataId:%s, group:%s", err.Error(), c.Addr, c.Namespace, c.DataId, c.Group)
+		return err
+	}
+	c.save(content)
+
+	logger.Infof("nacos, load config successful from addr:%s, namespaceId:%s, dataId:%s, group:%s", c.Addr, c.Namespace, c.DataId, c.Group)
+	return nil
+}
+
+// load 获取配置内容, nacos 不可用时使用本地快照中的旧配置启动, 并在后台重试直到 nacos 恢复
+func (c *NacosConfig) load() (content []byte, err error) {
+	// 配置被删除或为空时不使用快照, 快照只用于配置中心不可用的情况
+	if content, err = c.fetch(); err == nil || err == ErrAddrIsEmpty || err == ErrContentIsEmpty {
+		return content, err
+	}
+
+	path := snapshotPath(c.SnapshotDir, c.DisableSnapshot, "nacos", c.Namespace, c.Group, c.DataId)
+	stale, serr := readSnapshot(path)
+	if serr != nil {
+		return nil, err
+	}
+
+	logger.Warnf("nacos, running with stale config from snapshot:%s, addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", path, c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+	c.mu.Lock()
+	c.lastData = stale
+	c.mu.Unlock()
+	c.setStale(true)
+	c.recover(stale, c.fetch, func(content []byte) {
+		logger.Infof("nacos, recovered from stale config, addr:%s, namespaceId:%s, dataId:%s, group:%s", c.Addr, c.Namespace, c.DataId, c.Group)
+		c.apply(content)
+	})
+	return stale, nil
+}
+
+// apply 将 nacos 恢复后获取到的配置交给 WatchConfig 注册的 Reloader
+func (c *NacosConfig) apply(content []byte) {
+	c.mu.Lock()
+	r := c.reloader
+	c.mu.Unlock()
+
+	if r == nil {
+		logger.Warnf("nacos, config differs from snapshot but is not watched, use WatchConfig to apply it at runtime, dataId:%s, group:%s", c.DataId, c.Group)
+		return
+	}
+	decoder, err := GetDecoder(c.Format, c.DataId)
+	if err == nil {
+		err = r.ReloadWith(decoder, content)
+	}
+	if err != nil {
+		logger.Errorf("nacos, failed to reload config from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+		return
+	}
+	c.save(content)
+}
+
+// save 保存本地快照, 只在配置解码、校验通过或热更新成功后调用, 避免错误的配置覆盖快照
+func (c *NacosConfig) save(content []byte) {
+	saveSnapshot(snapshotPath(c.SnapshotDir, c.DisableSnapshot, "nacos", c.Namespace, c.Group, c.DataId), content)
+}
+
+// fetch 获取 nacos 配置内容, 客户端不存在时先创建客户端
+func (c *NacosConfig) fetch() (content []byte, err error) {
 	if len(c.Addr) == 0 {
-		return ErrAddrIsEmpty
+		return nil, ErrAddrIsEmpty
 	}
 
+	if c.Client == nil {
+		if err = c.newClient(); err != nil {
+			return nil, err
+		}
+	}
+
+	// get nacos config
+	data, err := c.Client.GetConfig(vo.ConfigParam{
+		DataId: c.DataId,
+		Group:  c.Group,
+	})
+	if err != nil {
+		logger.Errorf("nacos, failed to get config content from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		logger.Errorf("nacos, config content is empty from addr:%s, namespaceId:%s, dataId:%s, group:%s", c.Addr, c.Namespace, c.DataId, c.Group)
+		return nil, ErrContentIsEmpty
+	}
+
+	c.mu.Lock()
+	c.lastData = []byte(data)
+	c.mu.Unlock()
+	return []byte(data), nil
+}
+
+// newClient 创建 nacos 配置客户端
+func (c *NacosConfig) newClient() (err error) {
+
 	// nacos 相关参数配置,具体配置可参考 https://github.com/nacos-group/nacos-sdk-go
 
 	ipAddr, hPort, _ := net.SplitHostPort(c.Addr)
@@ -85,45 +261,65 @@ func (c *NacosConfig) LoadNacosConfig(conf interface{}) (err error) {
 		logger.Errorf("failed create nacos:%s client, err:%s", c.Addr, err.Error())
 		return err
 	}
-
-	// get nacos config
-	content, err := c.Client.GetConfig(vo.ConfigParam{
-		DataId: c.DataId,
-		Group:  c.Group,
-	})
-	if err != nil {
-		logger.Errorf("nacos, failed to get config content from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
-		return err
-	}
-
-	if len(content) == 0 {
-		logger.Errorf("nacos, config content is empty from addr:%s, namespaceId:%s, dataId:%s, group:%s", c.Addr, c.Namespace, c.DataId, c.Group)
-		return ErrContentIsEmpty
-	}
-
-	if _, err = toml.NewDecoder(bytes.NewBuffer([]byte(content))).Decode(conf); err != nil {
-		logger.Errorf("nacos, failed to decode content from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
-		return err
-	}
-
-	logger.Infof("nacos, load config successful from addr:%s, namespaceId:%s, dataId:%s, group:%s", c.Addr, c.Namespace, c.DataId, c.Group)
 	return nil
 }
 
 // ListenConfig 配置监听
+//
+// Deprecated: 变更的配置直接解码到正在使用的 conf 上, 读写存在竞争, 请使用 WatchConfig
 func (c *NacosConfig) ListenConfig(conf interface{}) {
+	decoder, err := GetDecoder(c.Format, c.DataId)
+	if err != nil {
+		logger.Errorf("nacos, unknown config format:%s of dataId:%s", c.Format, c.DataId)
+		return
+	}
+
 	_ = c.Client.ListenConfig(vo.ConfigParam{
 		DataId: c.DataId, Group: c.Group, OnChange: func(namespace, group, dataId, data string) {
 			if len(data) != 0 {
-				if _, err := toml.NewDecoder(bytes.NewBuffer([]byte(data))).Decode(conf); err != nil {
+				if err := decode(decoder, []byte(data), conf); err != nil {
 					logger.Errorf("nacos, failed to decode content from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
 				}
 			}
 		}})
 }
 
-// CancelListenConfig 取消配置监听
+// WatchConfig 配置监听, 变更的配置由 r 解码到新的副本, 校验通过后原子替换并通知 r 上注册的回调
+func (c *NacosConfig) WatchConfig(r *Reloader) (err error) {
+	if c.Client == nil {
+		return ErrClientNotInit
+	}
+
+	decoder, err := GetDecoder(c.Format, c.DataId)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.reloader = r
+	c.mu.Unlock()
+
+	if err = c.Client.ListenConfig(vo.ConfigParam{
+		DataId: c.DataId, Group: c.Group, OnChange: func(namespace, group, dataId, data string) {
+			c.mu.Lock()
+			c.lastData = []byte(data)
+			c.mu.Unlock()
+			if err := r.ReloadWith(decoder, []byte(data)); err != nil {
+				logger.Errorf("nacos, failed to reload config from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+				return
+			}
+			// 保存变更后的配置, 配置中心不可用时重启也能使用最新的配置
+			c.save([]byte(data))
+		}}); err != nil {
+		logger.Errorf("nacos, failed to listen config from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+		return err
+	}
+	return nil
+}
+
+// CancelListenConfig 取消配置监听并停止快照恢复的重试, 同时适用于 ListenConfig 与 WatchConfig
 func (c *NacosConfig) CancelListenConfig() (err error) {
+	c.stopRecover()
 	if err = c.Client.CancelListenConfig(vo.ConfigParam{DataId: c.DataId, Group: c.Group}); err != nil {
 		logger.Errorf("nacos, failed to cancel config listen from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
 		return err
@@ -133,35 +329,203 @@ func (c *NacosConfig) CancelListenConfig() (err error) {
 
 // LoadConsulConfig 引导 consul 配置数据给 conf
 func (c *ConsulConfig) LoadConsulConfig(conf interface{}) (err error) {
-	if len(c.Addr) == 0 {
-		return ErrAddrIsEmpty
+	decoder, err := GetDecoder(c.Format, c.ServiceId)
+	if err != nil {
+		logger.Errorf("consul, unknown config format:%s of serviceId:%s", c.Format, c.ServiceId)
+		return err
 	}
 
-	c.Client, err = api.NewClient(&api.Config{
-		Address:   c.Addr,
-		Namespace: c.Namespace,
-	})
+	content, err := c.load()
 	if err != nil {
-		logger.Errorf("failed create consul:%s client, err:%s", c.Addr, err.Error())
 		return err
 	}
 
-	content, _, err := c.Client.KV().Get(c.ServiceId, nil)
+	if err = decode(decoder, content, conf); err != nil {
+		logger.Errorf("consul, failed to decode content from addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+		return err
+	}
+
+	if err = Validate(conf); err != nil {
+		logger.Errorf("consul, %s, addr:%s, serviceId:%s", err.Error(), c.Addr, c.ServiceId)
+		return err
+	}
+	c.save(content)
+
+	logger.Infof("consul, read config successful from addr:%s, serviceId:%s", c.Addr, c.ServiceId)
+	return nil
+}
+
+// load 获取配置内容, consul 不可用时使用本地快照中的旧配置启动, 并在后台重试直到 consul 恢复
+func (c *ConsulConfig) load() (content []byte, err error) {
+	// 配置被删除或为空时不使用快照, 快照只用于配置中心不可用的情况
+	if content, err = c.fetch(); err == nil || err == ErrAddrIsEmpty || err == ErrContentIsEmpty {
+		return content, err
+	}
+
+	path := snapshotPath(c.SnapshotDir, c.DisableSnapshot, "consul", c.Namespace, c.ServiceId)
+	stale, serr := readSnapshot(path)
+	if serr != nil {
+		return nil, err
+	}
+
+	logger.Warnf("consul, running with stale config from snapshot:%s, addr:%s, serviceId:%s, err:%s", path, c.Addr, c.ServiceId, err.Error())
+	c.mu.Lock()
+	c.lastData = stale
+	c.mu.Unlock()
+	c.setStale(true)
+	c.recover(stale, c.fetch, func(content []byte) {
+		logger.Infof("consul, recovered from stale config, addr:%s, serviceId:%s", c.Addr, c.ServiceId)
+		c.apply(content)
+	})
+	return stale, nil
+}
+
+// apply 将 consul 恢复后获取到的配置交给 WatchConfig 注册的 Reloader
+func (c *ConsulConfig) apply(content []byte) {
+	c.mu.Lock()
+	r := c.reloader
+	c.mu.Unlock()
+
+	if r == nil {
+		logger.Warnf("consul, config differs from snapshot but is not watched, use WatchConfig to apply it at runtime, serviceId:%s", c.ServiceId)
+		return
+	}
+	decoder, err := GetDecoder(c.Format, c.ServiceId)
+	if err == nil {
+		err = r.ReloadWith(decoder, content)
+	}
+	if err != nil {
+		logger.Errorf("consul, failed to reload config from addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+		return
+	}
+	c.save(content)
+}
+
+// save 保存本地快照, 只在配置解码、校验通过或热更新成功后调用, 避免错误的配置覆盖快照
+func (c *ConsulConfig) save(content []byte) {
+	saveSnapshot(snapshotPath(c.SnapshotDir, c.DisableSnapshot, "consul", c.Namespace, c.ServiceId), content)
+}
+
+// fetch 获取 consul 配置内容, 客户端不存在时先创建客户端, 获取成功后记录 index 供 WatchConfig 阻塞查询使用
+func (c *ConsulConfig) fetch() (content []byte, err error) {
+	if len(c.Addr) == 0 {
+		return nil, ErrAddrIsEmpty
+	}
+
+	if c.Client == nil {
+		c.Client, err = api.NewClient(&api.Config{
+			Address:   c.Addr,
+			Namespace: c.Namespace,
+		})
+		if err != nil {
+			logger.Errorf("failed create consul:%s client, err:%s", c.Addr, err.Error())
+			return nil, err
+		}
+	}
+
+	pair, meta, err := c.Client.KV().Get(c.ServiceId, nil)
 	if err != nil {
 		logger.Errorf("consul, failed to get config content from addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
-		return err
+		return nil, err
 	}
 
-	if content == nil {
+	if pair == nil {
 		logger.Errorf("consul, config content is empty from addr:%s, serviceId:%s", c.Addr, c.ServiceId)
-		return ErrContentIsEmpty
+		return nil, ErrContentIsEmpty
 	}
 
-	if _, err = toml.NewDecoder(bytes.NewBuffer(content.Value)).Decode(conf); err != nil {
-		logger.Errorf("consul, failed to decode content from addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+	c.mu.Lock()
+	c.lastIndex, c.lastData, c.modifyIndex = meta.LastIndex, pair.Value, pair.ModifyIndex
+	c.mu.Unlock()
+	return pair.Value, nil
+}
+
+// WatchConfig 配置监听, 以阻塞查询的方式监听 ServiceId 对应的 KV,
+// 变更的配置由 r 解码到新的副本, 校验通过后原子替换并通知 r 上注册的回调
+func (c *ConsulConfig) WatchConfig(r *Reloader) (err error) {
+	if c.Client == nil {
+		return ErrClientNotInit
+	}
+
+	decoder, err := GetDecoder(c.Format, c.ServiceId)
+	if err != nil {
 		return err
 	}
 
-	logger.Infof("consul, read config successful from addr:%s, serviceId:%s", c.Addr, c.ServiceId)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lastIndex, lastData := c.lastIndex, c.lastData
+	if err = c.listener.start(func(ctx context.Context) {
+		c.watch(ctx, lastIndex, lastData, func(data []byte) {
+			if err := r.ReloadWith(decoder, data); err != nil {
+				logger.Errorf("consul, failed to reload config from addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+				return
+			}
+			// 保存变更后的配置, 配置中心不可用时重启也能使用最新的配置
+			c.save(data)
+		})
+	}); err != nil {
+		return err
+	}
+	c.reloader = r
+	return nil
+}
+
+// CancelListenConfig 取消配置监听并停止快照恢复的重试, 会等待监听协程退出后返回
+func (c *ConsulConfig) CancelListenConfig() (err error) {
+	c.stopRecover()
+	c.listener.stop()
 	return nil
 }
+
+// watch 阻塞查询 ServiceId 对应的 KV, 数据变化时调用 onChange, 出错后按指数退避重试, 直到 ctx 取消
+func (c *ConsulConfig) watch(ctx context.Context, lastIndex uint64, lastData []byte, onChange func(data []byte)) {
+	backoff := retryMinBackoff
+	for {
+		q := &api.QueryOptions{WaitIndex: lastIndex, WaitTime: consulWatchWaitTime}
+		content, meta, err := c.Client.KV().Get(c.ServiceId, q.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Errorf("consul, failed to watch config from addr:%s, serviceId:%s, retry after %s, err:%s", c.Addr, c.ServiceId, backoff, err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > retryMaxBackoff {
+				backoff = retryMaxBackoff
+			}
+			continue
+		}
+		backoff = retryMinBackoff
+
+		// index 变小说明 consul 数据被重置, 以本次返回的 index 重新开始阻塞查询
+		if meta.LastIndex < lastIndex {
+			lastIndex = 0
+		}
+		if meta.LastIndex == lastIndex {
+			continue
+		}
+		// index 为 0 时阻塞查询会立即返回, 至少从 1 开始
+		if lastIndex = meta.LastIndex; lastIndex == 0 {
+			lastIndex = 1
+		}
+
+		if content == nil {
+			logger.Warnf("consul, config content is deleted from addr:%s, serviceId:%s", c.Addr, c.ServiceId)
+			continue
+		}
+		changed := !bytes.Equal(content.Value, lastData)
+		lastData = content.Value
+
+		c.mu.Lock()
+		c.lastIndex, c.lastData, c.modifyIndex = lastIndex, lastData, content.ModifyIndex
+		c.mu.Unlock()
+
+		if changed {
+			onChange(content.Value)
+		}
+	}
+}
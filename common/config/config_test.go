@@ -2,10 +2,29 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/config_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+	"go.uber.org/zap/zapcore"
+)
+
 func TestLoadFileConfig(t *testing.T) {
 	conf := make(map[string]interface{})
 	c := &FileConfig{Path: "config_test.toml"}
@@ -59,3 +78,941 @@ func TestFileConsulConfig(t *testing.T) {
 	err := c.LoadConsulConfig(&conf)
 	t.Log(conf, err)
 }
+
+type testRedisConf struct {
+	Host string `toml:"host"`
+	Port int    `toml:"port"`
+}
+
+type testAppConf struct {
+	AppName string        `toml:"appName"`
+	Redis   testRedisConf `toml:"redis"`
+}
+
+func TestReloader(t *testing.T) {
+	conf := &testAppConf{}
+	c := &FileConfig{Path: "config_test.toml"}
+	if err := c.LoadFileConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReloader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotOld, gotNew *testAppConf
+	var gotKeys ChangedKeys
+	r.OnChange(func(old, new interface{}, changed ChangedKeys) {
+		gotOld, gotNew, gotKeys = old.(*testAppConf), new.(*testAppConf), changed
+	})
+
+	if err = r.Reload([]byte("appName = \"demo\"\n[redis]\nhost = \"10.0.0.1\"\nport = 6379\n")); err != nil {
+		t.Fatal(err)
+	}
+	if gotOld != conf || gotNew != r.Get().(*testAppConf) {
+		t.Fatalf("unexpected callback configs, old:%+v, new:%+v", gotOld, gotNew)
+	}
+	if !gotKeys.Has("redis") || len(gotKeys) != 3 {
+		t.Fatalf("unexpected changed keys:%v", gotKeys)
+	}
+
+	// 解码失败的配置不会被替换
+	if err = r.Reload([]byte("appName = ")); err == nil {
+		t.Fatal("expect decode err")
+	}
+	if r.Get().(*testAppConf).Redis.Host != "10.0.0.1" {
+		t.Fatalf("unexpected config:%+v", r.Get())
+	}
+
+	// 只有 redis.port 变化
+	gotKeys = nil
+	if err = r.Reload([]byte("appName = \"demo\"\n[redis]\nhost = \"10.0.0.1\"\nport = 6380\n")); err != nil {
+		t.Fatal(err)
+	}
+	if len(gotKeys) != 1 || gotKeys[0] != "redis.port" {
+		t.Fatalf("unexpected changed keys:%v", gotKeys)
+	}
+}
+
+// fakeConsulKV 模拟 consul KV 的 HTTP 接口, 支持 index 阻塞查询与 CAS 写入,
+// 以 .history 结尾的 key 单独存储, 其余 key 共用同一个 value
+type fakeConsulKV struct {
+	mu      sync.Mutex
+	changed chan struct{}
+	index   uint64
+	value   []byte
+	history map[string][]byte
+	fails   int  // 接下来的 fails 次查询返回 500
+	deleted bool // 为 true 时查询返回 404, 模拟配置被删除
+}
+
+func newFakeConsulKV(value string) *fakeConsulKV {
+	return &fakeConsulKV{changed: make(chan struct{}), index: 10, value: []byte(value), history: make(map[string][]byte)}
+}
+
+func (f *fakeConsulKV) set(value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setLocked([]byte(value))
+}
+
+func (f *fakeConsulKV) setLocked(value []byte) {
+	f.index++
+	f.value = value
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsulKV) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	if f.fails > 0 {
+		f.fails--
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	index, changed, deleted := f.index, f.changed, f.deleted
+	f.mu.Unlock()
+	if deleted && req.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	key := req.URL.Path[len("/v1/kv/"):]
+	if req.Method == http.MethodPut {
+		f.put(w, req, key)
+		return
+	}
+
+	if wait, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64); wait >= index {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	value, modifyIndex := f.value, f.index
+	if strings.HasSuffix(key, historySuffix) {
+		var ok bool
+		if value, ok = f.history[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		modifyIndex = uint64(len(value))
+	}
+	body, _ := json.Marshal([]map[string]interface{}{{
+		"Key": key, "Value": value, "ModifyIndex": modifyIndex,
+	}})
+	_, _ = w.Write(body)
+}
+
+func (f *fakeConsulKV) put(w http.ResponseWriter, req *http.Request, key string) {
+	value, _ := ioutil.ReadAll(req.Body)
+	cas, _ := strconv.ParseUint(req.URL.Query().Get("cas"), 10, 64)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// history 的 ModifyIndex 以内容长度模拟
+	if strings.HasSuffix(key, historySuffix) {
+		if uint64(len(f.history[key])) != cas {
+			_, _ = w.Write([]byte("false"))
+			return
+		}
+		f.history[key] = value
+	} else {
+		if f.index != cas {
+			_, _ = w.Write([]byte("false"))
+			return
+		}
+		f.setLocked(value)
+	}
+	_, _ = w.Write([]byte("true"))
+}
+
+func TestConsulWatchConfig(t *testing.T) {
+	consulWatchWaitTime, retryMinBackoff = time.Second, time.Millisecond*10
+
+	kv := newFakeConsulKV("appName = \"demo\"\n[redis]\nport = 6379\n")
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+
+	conf := &testAppConf{}
+	c := &ConsulConfig{Addr: srv.URL, ServiceId: "test", SnapshotDir: t.TempDir()}
+	if err := c.LoadConsulConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Redis.Port != 6379 {
+		t.Fatalf("unexpected config:%+v", conf)
+	}
+
+	r, _ := NewReloader(conf)
+	changes := make(chan ChangedKeys, 10)
+	r.OnChange(func(old, new interface{}, changed ChangedKeys) {
+		changes <- changed
+	})
+	if err := c.WatchConfig(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WatchConfig(r); err != ErrAlreadyListening {
+		t.Fatalf("expect ErrAlreadyListening, got:%v", err)
+	}
+
+	for i, port := range []int{6380, 6381} {
+		if i == 1 {
+			// consul 出错时按退避重试, 恢复后继续监听
+			kv.mu.Lock()
+			kv.fails = 3
+			kv.mu.Unlock()
+		}
+		kv.set(fmt.Sprintf("appName = \"demo\"\n[redis]\nport = %d\n", port))
+		select {
+		case changed := <-changes:
+			if len(changed) != 1 || changed[0] != "redis.port" {
+				t.Fatalf("unexpected changed keys:%v", changed)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("wait config change timeout")
+		}
+		if got := r.Get().(*testAppConf).Redis.Port; got != port {
+			t.Fatalf("unexpected redis port:%d", got)
+		}
+	}
+
+	if err := c.CancelListenConfig(); err != nil {
+		t.Fatal(err)
+	}
+	kv.set("appName = \"demo\"\n[redis]\nport = 6390\n")
+	select {
+	case changed := <-changes:
+		t.Fatalf("unexpected change after cancel:%v", changed)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
+
+func TestGetDecoder(t *testing.T) {
+	cases := []struct {
+		format, name string
+		want         Decoder
+	}{
+		{"", "config.toml", TomlDecoder},
+		{"", "app.yaml", YamlDecoder},
+		{"", "app.YML", YamlDecoder},
+		{"", "app/config.json", JsonDecoder},
+		{"", "test", TomlDecoder},
+		{"json", "app.yaml", JsonDecoder},
+	}
+	for _, c := range cases {
+		d, err := GetDecoder(c.format, c.name)
+		if err != nil || fmt.Sprint(d) != fmt.Sprint(c.want) {
+			t.Fatalf("unexpected decoder for format:%q, name:%q, err:%v", c.format, c.name, err)
+		}
+	}
+
+	if _, err := GetDecoder("ini", "app.toml"); err != ErrUnknownFormat {
+		t.Fatalf("expect ErrUnknownFormat, got:%v", err)
+	}
+}
+
+func TestLoadFileConfigFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"app.yaml": "appName: demo\nredis:\n  host: 10.0.0.1\n  port: 6379\n",
+		"app.json": `{"appName": "demo", "redis": {"host": "10.0.0.1", "port": 6379}}`,
+	}
+	for name, content := range files {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		conf := &struct {
+			AppName string `yaml:"appName" json:"appName"`
+			Redis   struct {
+				Host string `yaml:"host" json:"host"`
+				Port int    `yaml:"port" json:"port"`
+			} `yaml:"redis" json:"redis"`
+		}{}
+		if err := (&FileConfig{Path: path}).LoadFileConfig(conf); err != nil {
+			t.Fatal(err)
+		}
+		if conf.AppName != "demo" || conf.Redis.Host != "10.0.0.1" || conf.Redis.Port != 6379 {
+			t.Fatalf("unexpected config from %s:%+v", name, conf)
+		}
+	}
+}
+
+func TestLoadLayeredConfig(t *testing.T) {
+	path := t.TempDir() + "/app.toml"
+	content := "appName = \"file\"\n[redis]\nhost = \"10.0.0.1\"\nmaxIdle = 5\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_REDIS_MAX_IDLE", "20")
+	t.Setenv("TEST_KAFKA_BROKERS", "k1:9092,k2:9092")
+
+	conf := &struct {
+		AppName string `toml:"appName"`
+		Debug   bool   `toml:"debug"`
+		Redis   struct {
+			Host    string        `toml:"host" flag:"addr"`
+			Port    int           `toml:"port" default:"6379"`
+			MaxIdle int           `toml:"maxIdle"`
+			Timeout time.Duration `toml:"timeout" default:"3s"`
+		} `toml:"redis"`
+		Kafka struct {
+			Brokers []string `toml:"brokers" env:"BROKERS"`
+		} `toml:"kafka"`
+	}{AppName: "default"}
+
+	c := &LayeredConfig{
+		File:      &FileConfig{Path: path},
+		EnvPrefix: "TEST_",
+		Args:      []string{"-debug", "--redis.addr", "10.0.0.2", "-unknown=1", "-appName=flag"},
+	}
+	if err := c.LoadLayeredConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.AppName != "flag" || !conf.Debug || conf.Redis.Host != "10.0.0.2" || conf.Redis.Port != 6379 ||
+		conf.Redis.MaxIdle != 20 || conf.Redis.Timeout != time.Second*3 || len(conf.Kafka.Brokers) != 2 {
+		t.Fatalf("unexpected config:%+v", conf)
+	}
+
+	want := map[string]Layer{
+		"appName":       LayerFlag,
+		"debug":         LayerFlag,
+		"redis.host":    LayerFlag,
+		"redis.port":    LayerDefault,
+		"redis.maxIdle": LayerEnv,
+		"redis.timeout": LayerDefault,
+		"kafka.brokers": LayerEnv,
+	}
+	origins := c.Origins()
+	for key, layer := range want {
+		if origins[key] != layer {
+			t.Fatalf("unexpected origin of %s:%s, want:%s", key, origins[key], layer)
+		}
+	}
+
+	c.Args = []string{}
+	if err := c.LoadLayeredConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if origins = c.Origins(); origins["appName"] != LayerFile || origins["redis.host"] != LayerFile {
+		t.Fatalf("unexpected origins:%v", origins)
+	}
+}
+
+func TestLayeredHttpConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = fmt.Fprint(w, "appName = \"http\"\n[redis]\nport = 6380\n")
+	}))
+	defer srv.Close()
+
+	conf := &testAppConf{}
+	c := &LayeredConfig{Http: &HttpConfig{Url: srv.URL + "/app.toml", DisableSnapshot: true}}
+	if err := c.LoadLayeredConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.AppName != "http" || conf.Redis.Port != 6380 || c.Origins()["redis.port"] != LayerHttp {
+		t.Fatalf("unexpected config:%+v, origins:%v", conf, c.Origins())
+	}
+}
+
+type testValidateConf struct {
+	Env   string `toml:"env" validate:"oneof=dev test prod"`
+	Mysql struct {
+		DSN     string `toml:"dsn" validate:"required"`
+		Timeout string `toml:"timeout" validate:"omitempty,duration"`
+	} `toml:"mysql"`
+	Redis struct {
+		Port int `toml:"port" validate:"min=1,max=65535"`
+	} `toml:"redis"`
+	Webhook string `toml:"webhook" validate:"omitempty,url"`
+}
+
+func TestValidate(t *testing.T) {
+	conf := &testValidateConf{}
+	conf.Env = "dev"
+	conf.Mysql.DSN = "root:123456@tcp(localhost:3306)/demo"
+	conf.Mysql.Timeout = "3s"
+	conf.Redis.Port = 6379
+	if err := Validate(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Env, conf.Mysql.DSN, conf.Mysql.Timeout, conf.Redis.Port, conf.Webhook = "local", "", "3", 70000, "http://root:123456@[bad"
+	err := Validate(conf)
+	invalid, ok := err.(ValidateError)
+	if !ok {
+		t.Fatalf("expect ValidateError, got:%v", err)
+	}
+	paths := make([]string, 0)
+	for _, f := range invalid {
+		paths = append(paths, f.Path+":"+f.Rule)
+	}
+	if fmt.Sprint(paths) != "[env:oneof mysql.dsn:required mysql.timeout:duration redis.port:max webhook:url]" {
+		t.Fatalf("unexpected invalid fields:%v", paths)
+	}
+	// 错误信息中不包含字段的值
+	if strings.Contains(err.Error(), "123456") || strings.Contains(err.Error(), "70000") {
+		t.Fatalf("unexpected value in err:%s", err.Error())
+	}
+	t.Log(err)
+
+	// 校验失败的热加载配置不会被替换
+	r, _ := NewReloader(&testValidateConf{})
+	if err = r.Reload([]byte("env = \"prod\"\n[redis]\nport = 6379\n")); err == nil {
+		t.Fatal("expect validate err")
+	}
+	if r.Get().(*testValidateConf).Env != "" {
+		t.Fatalf("invalid config should not be applied:%+v", r.Get())
+	}
+}
+
+func TestSecretConfig(t *testing.T) {
+	key := []byte("b6c1cd0fe6e55f22fb483096822b5d1c")
+	password, err := Encrypt([]byte("123456"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dsn, _ := Encrypt([]byte("root:123456@tcp(localhost:3306)/demo"), key)
+	content := fmt.Sprintf("[kafka]\nsaslPassword = %q\n[mysql]\ndsn = %q\n[[servers]]\npassword = %q\n", password, dsn, password)
+
+	conf := &struct {
+		Kafka struct {
+			SaslPassword string `toml:"saslPassword"`
+		} `toml:"kafka"`
+		Mysql struct {
+			DSN string `toml:"dsn"`
+		} `toml:"mysql"`
+		Servers []map[string]interface{} `toml:"servers"`
+	}{}
+
+	SetKeyProvider(EnvKeyProvider{Name: "TEST_CONFIG_ENCRYPT_KEY"})
+	defer SetKeyProvider(EnvKeyProvider{})
+	if err = decode(TomlDecoder, []byte(content), conf); err == nil {
+		t.Fatal("expect key is empty err")
+	}
+
+	keyFile := t.TempDir() + "/key"
+	_ = os.WriteFile(keyFile, append(key, '\n'), 0600)
+	SetKeyProvider(FileKeyProvider{Path: keyFile})
+	if err = decode(TomlDecoder, []byte(content), conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Kafka.SaslPassword != "123456" || conf.Mysql.DSN != "root:123456@tcp(localhost:3306)/demo" || conf.Servers[0]["password"] != "123456" {
+		t.Fatalf("unexpected config:%+v", conf)
+	}
+
+	SetKeyProvider(KeyProviderFunc(func() ([]byte, error) { return []byte("00000000000000000000000000000000"), nil }))
+	if err = decode(TomlDecoder, []byte(content), conf); err == nil {
+		t.Fatal("expect decrypt err with wrong key")
+	}
+	t.Log(err)
+}
+
+func TestConsulSnapshotFallback(t *testing.T) {
+	consulWatchWaitTime, retryMinBackoff = time.Second, time.Millisecond*10
+
+	kv := newFakeConsulKV("appName = \"demo\"\n[redis]\nport = 6379\n")
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	if err := (&ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}).LoadConsulConfig(&testAppConf{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// consul 不可用时使用快照启动
+	kv.mu.Lock()
+	kv.fails = 1 << 20
+	kv.mu.Unlock()
+
+	conf := &testAppConf{}
+	c := &ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}
+	if err := c.LoadConsulConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Stale() || conf.Redis.Port != 6379 {
+		t.Fatalf("expect stale config from snapshot, stale:%v, conf:%+v", c.Stale(), conf)
+	}
+
+	r, _ := NewReloader(conf)
+	if err := c.WatchConfig(r); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.CancelListenConfig() }()
+
+	// consul 恢复后退出 stale 状态, 并应用新的配置
+	kv.set("appName = \"demo\"\n[redis]\nport = 6380\n")
+	kv.mu.Lock()
+	kv.fails = 0
+	kv.mu.Unlock()
+	for i := 0; i < 100 && (c.Stale() || r.Get().(*testAppConf).Redis.Port != 6380); i++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+	if c.Stale() || r.Get().(*testAppConf).Redis.Port != 6380 {
+		t.Fatalf("expect recovered config, stale:%v, conf:%+v", c.Stale(), r.Get())
+	}
+
+	// 禁用快照时 consul 不可用直接返回错误
+	kv.mu.Lock()
+	kv.fails = 1 << 20
+	kv.mu.Unlock()
+	if err := (&ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir, DisableSnapshot: true}).LoadConsulConfig(&testAppConf{}); err == nil {
+		t.Fatal("expect err when snapshot is disabled")
+	}
+}
+
+func TestSnapshotRecoverStop(t *testing.T) {
+	consulWatchWaitTime, retryMinBackoff = time.Second, time.Millisecond*10
+
+	kv := newFakeConsulKV("appName = \"demo\"\n[redis]\nport = 6379\n")
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+	setKV := func(fails int, deleted bool) {
+		kv.mu.Lock()
+		kv.fails, kv.deleted = fails, deleted
+		kv.mu.Unlock()
+	}
+
+	dir := t.TempDir()
+	if err := (&ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}).LoadConsulConfig(&testAppConf{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// CancelListenConfig 后不再重试
+	setKV(1<<20, false)
+	c := &ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}
+	if err := c.LoadConsulConfig(&testAppConf{}); err != nil || !c.Stale() {
+		t.Fatalf("expect stale config from snapshot, stale:%v, err:%v", c.Stale(), err)
+	}
+	_ = c.CancelListenConfig()
+	setKV(0, false)
+	time.Sleep(time.Millisecond * 100)
+	if !c.Stale() {
+		t.Fatal("expect recover stopped after CancelListenConfig")
+	}
+
+	// 配置被删除时停止重试
+	setKV(1<<20, false)
+	c = &ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}
+	if err := c.LoadConsulConfig(&testAppConf{}); err != nil || !c.Stale() {
+		t.Fatalf("expect stale config from snapshot, stale:%v, err:%v", c.Stale(), err)
+	}
+	defer func() { _ = c.CancelListenConfig() }()
+	setKV(0, true)
+	time.Sleep(time.Millisecond * 100)
+	setKV(0, false)
+	time.Sleep(time.Millisecond * 100)
+	if !c.Stale() {
+		t.Fatal("expect recover stopped after config is deleted")
+	}
+
+	// 配置被删除时不使用快照启动
+	setKV(0, true)
+	if err := (&ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}).LoadConsulConfig(&testAppConf{}); err != ErrContentIsEmpty {
+		t.Fatalf("expect ErrContentIsEmpty, got:%v", err)
+	}
+}
+
+func TestHttpWatchConfig(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		version     = 1
+		notModified int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		etag := strconv.Quote(strconv.Itoa(version))
+		if req.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = fmt.Fprintf(w, "appName: demo\nredis:\n  port: %d\n", 6378+version)
+	}))
+	defer srv.Close()
+
+	conf := &testAppConf{}
+	source, err := (&SourceConfig{
+		Type: SourceHttp,
+		Http: &HttpConfig{Url: srv.URL + "/app.yaml", IntervalMs: 20, DisableSnapshot: true},
+	}).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = source.Load(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Redis.Port != 6379 {
+		t.Fatalf("unexpected config:%+v", conf)
+	}
+
+	r, _ := NewReloader(conf)
+	changes := make(chan ChangedKeys, 10)
+	r.OnChange(func(old, new interface{}, changed ChangedKeys) {
+		changes <- changed
+	})
+	if err = source.WatchConfig(r); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = source.CancelListenConfig() }()
+
+	time.Sleep(time.Millisecond * 100)
+	mu.Lock()
+	if notModified == 0 {
+		t.Fatal("expect conditional requests with If-None-Match")
+	}
+	version = 2
+	mu.Unlock()
+
+	select {
+	case changed := <-changes:
+		if len(changed) != 1 || changed[0] != "redis.port" {
+			t.Fatalf("unexpected changed keys:%v", changed)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait config change timeout")
+	}
+	if got := r.Get().(*testAppConf).Redis.Port; got != 6380 {
+		t.Fatalf("unexpected redis port:%d", got)
+	}
+}
+
+func TestHttpSnapshotRejected(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		content = "appName = \"demo\"\n[redis]\nport = 6379\n"
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = fmt.Fprint(w, content)
+	}))
+	defer srv.Close()
+	set := func(value string) {
+		mu.Lock()
+		content = value
+		mu.Unlock()
+	}
+
+	c := &HttpConfig{Url: srv.URL + "/app.toml", IntervalMs: 20, SnapshotDir: t.TempDir()}
+	path := snapshotPath(c.SnapshotDir, false, "http", c.Url)
+	conf := &testAppConf{}
+	if err := c.LoadHttpConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewReloader(conf)
+	changes := make(chan ChangedKeys, 10)
+	r.OnChange(func(old, new interface{}, changed ChangedKeys) {
+		changes <- changed
+	})
+	if err := c.WatchConfig(r); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.CancelListenConfig() }()
+
+	// 热更新失败的配置不覆盖快照
+	set("[redis\nport = ")
+	time.Sleep(time.Millisecond * 200)
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "6379") {
+		t.Fatalf("snapshot overwritten by rejected config:%s", data)
+	}
+
+	set("appName = \"demo\"\n[redis]\nport = 6380\n")
+	select {
+	case <-changes:
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait config change timeout")
+	}
+	// 快照在回调通知之后保存
+	deadline := time.Now().Add(time.Second)
+	for data, _ := os.ReadFile(path); !strings.Contains(string(data), "6380"); data, _ = os.ReadFile(path) {
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot not saved after reload:%s", data)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestSourceConfig(t *testing.T) {
+	if _, err := (&SourceConfig{Type: "zookeeper"}).Source(); !errors.Is(err, ErrUnknownSource) {
+		t.Fatalf("expect ErrUnknownSource, got:%v", err)
+	}
+	if _, err := (&SourceConfig{Type: SourceEtcd}).Source(); err == nil {
+		t.Fatal("expect err when source is not configured")
+	}
+	source, err := (&SourceConfig{Type: SourceFile, File: &FileConfig{Path: "test.toml"}}).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = source.WatchConfig(nil); err != ErrWatchNotSupported {
+		t.Fatalf("expect ErrWatchNotSupported, got:%v", err)
+	}
+}
+
+func TestConsulPublish(t *testing.T) {
+	consulWatchWaitTime, retryMinBackoff = time.Second, time.Millisecond*10
+	key := []byte("0123456789abcdef")
+	SetKeyProvider(KeyProviderFunc(func() ([]byte, error) { return key, nil }))
+	defer SetKeyProvider(EnvKeyProvider{})
+
+	password, _ := Encrypt([]byte("secret"), key)
+	kv := newFakeConsulKV(fmt.Sprintf("appName = \"demo\"\n[redis]\nhost = \"%s\"\nport = 6379\n", password))
+	srv := httptest.NewServer(kv)
+	defer srv.Close()
+
+	conf := &testAppConf{}
+	c := &ConsulConfig{Addr: srv.URL, ServiceId: "test", SnapshotDir: t.TempDir()}
+	if err := c.LoadConsulConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Redis.Host != "secret" {
+		t.Fatalf("unexpected config:%+v", conf)
+	}
+
+	conf.Redis.Port = 6380
+	if err := c.Publish(conf); err != nil {
+		t.Fatal(err)
+	}
+	kv.mu.Lock()
+	published := string(kv.value)
+	kv.mu.Unlock()
+	// 未修改的敏感值保留加密值
+	if !strings.Contains(published, password) || !strings.Contains(published, "6380") {
+		t.Fatalf("unexpected published content:%s", published)
+	}
+
+	// 被他人修改后发布冲突
+	kv.set("appName = \"demo\"\n[redis]\nport = 6381\n")
+	conf.Redis.Port = 6382
+	if err := c.Publish(conf); err != ErrPublishConflict {
+		t.Fatalf("expect ErrPublishConflict, got:%v", err)
+	}
+
+	history, err := c.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Version != 2 || history[0].Content != published {
+		t.Fatalf("unexpected history:%+v", history)
+	}
+
+	// 回滚到发布前的版本
+	if _, err = c.fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Rollback(history[1].Version); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.LoadConsulConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Redis.Port != 6379 || conf.Redis.Host != "secret" {
+		t.Fatalf("unexpected config after rollback:%+v", conf)
+	}
+	if history, _ = c.History(); len(history) != 3 || history[0].Version != 3 {
+		t.Fatalf("unexpected history:%+v", history)
+	}
+	if err = c.Rollback(100); err != ErrRevisionNotFound {
+		t.Fatalf("expect ErrRevisionNotFound, got:%v", err)
+	}
+}
+
+func TestWatchLogLevel(t *testing.T) {
+	type appConf struct {
+		LogLevelConf
+		AppName string `toml:"appName"`
+	}
+	prevLevel := logger.GetLevel()
+	defer func() {
+		logger.SetLevel(prevLevel)
+		logger.SetNamedLevels(nil)
+	}()
+
+	r, err := NewReloader(&appConf{LogLevelConf: LogLevelConf{LogLevel: "warn"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = WatchLogLevel(r); err != nil {
+		t.Fatal(err)
+	}
+	if logger.GetLevel() != zapcore.WarnLevel {
+		t.Fatalf("unexpected level:%s", logger.GetLevel())
+	}
+
+	if err = r.Reload([]byte("appName = \"demo\"\nlogLevel = \"error\"\n[logLevels]\nkafka = \"debug\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if named := logger.NamedLevels(); logger.GetLevel() != zapcore.ErrorLevel || named["kafka"] != zapcore.DebugLevel {
+		t.Fatalf("unexpected level:%s, named:%v", logger.GetLevel(), named)
+	}
+
+	// 非法的级别不会被应用
+	if err = r.Reload([]byte("appName = \"demo\"\nlogLevel = \"verbose\"\n")); err == nil {
+		t.Fatal("expect validate err")
+	}
+	if err = r.Reload([]byte("appName = \"demo\"\nlogLevel = \"info\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if named := logger.NamedLevels(); logger.GetLevel() != zapcore.InfoLevel || len(named) != 0 {
+		t.Fatalf("unexpected level:%s, named:%v", logger.GetLevel(), named)
+	}
+
+	if err = WatchLogLevel(&Reloader{}); err != ErrNoLogLevelConf {
+		t.Fatalf("expect ErrNoLogLevelConf, got:%v", err)
+	}
+}
+
+func TestConsulWatchSnapshot(t *testing.T) {
+	consulWatchWaitTime, retryMinBackoff = time.Second, time.Millisecond*10
+
+	kv := newFakeConsulKV("appName = \"demo\"\n[redis]\nport = 6379\n")
+	srv := httptest.NewServer(kv)
+	dir := t.TempDir()
+
+	conf := &testAppConf{}
+	c := &ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}
+	if err := c.LoadConsulConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewReloader(conf)
+	if err := c.WatchConfig(r); err != nil {
+		t.Fatal(err)
+	}
+	kv.set("appName = \"demo\"\n[redis]\nport = 6380\n")
+	for i := 0; i < 100 && r.Get().(*testAppConf).Redis.Port != 6380; i++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+	_ = c.CancelListenConfig()
+	srv.Close()
+
+	// consul 不可用时使用运行期间变更后的快照启动
+	conf = &testAppConf{}
+	c = &ConsulConfig{Addr: srv.URL, ServiceId: "app/test", SnapshotDir: dir}
+	if err := c.LoadConsulConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Stale() || conf.Redis.Port != 6380 {
+		t.Fatalf("expect changed config from snapshot, stale:%v, conf:%+v", c.Stale(), conf)
+	}
+}
+
+// fakeNacosClient 模拟 nacos 配置客户端, down 为 true 时获取配置失败
+type fakeNacosClient struct {
+	config_client.IConfigClient
+	mu       sync.Mutex
+	content  string
+	down     bool
+	onChange func(namespace, group, dataId, data string)
+}
+
+func (f *fakeNacosClient) GetConfig(param vo.ConfigParam) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return "", errors.New("nacos is down")
+	}
+	return f.content, nil
+}
+
+func (f *fakeNacosClient) ListenConfig(param vo.ConfigParam) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onChange = param.OnChange
+	return nil
+}
+
+func (f *fakeNacosClient) set(content string) {
+	f.mu.Lock()
+	f.content = content
+	onChange := f.onChange
+	f.mu.Unlock()
+	onChange("", "DEFAULT_GROUP", "app.toml", content)
+}
+
+func TestNacosWatchSnapshot(t *testing.T) {
+	client := &fakeNacosClient{content: "appName = \"demo\"\n[redis]\nport = 6379\n"}
+	dir := t.TempDir()
+
+	conf := &testAppConf{}
+	c := &NacosConfig{Client: client, Addr: "127.0.0.1:8848", DataId: "app.toml", Group: "DEFAULT_GROUP", SnapshotDir: dir}
+	if err := c.LoadNacosConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := NewReloader(conf)
+	if err := c.WatchConfig(r); err != nil {
+		t.Fatal(err)
+	}
+	// 校验失败的配置不保存快照
+	client.set("appName = \"demo\"\n[redis]\nport = \"bad\"\n")
+	client.set("appName = \"demo\"\n[redis]\nport = 6380\n")
+	if got := r.Get().(*testAppConf).Redis.Port; got != 6380 {
+		t.Fatalf("unexpected redis port:%d", got)
+	}
+
+	client.mu.Lock()
+	client.down = true
+	client.mu.Unlock()
+	conf = &testAppConf{}
+	c = &NacosConfig{Client: client, Addr: "127.0.0.1:8848", DataId: "app.toml", Group: "DEFAULT_GROUP", SnapshotDir: dir}
+	if err := c.LoadNacosConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Stale() || conf.Redis.Port != 6380 {
+		t.Fatalf("expect changed config from snapshot, stale:%v, conf:%+v", c.Stale(), conf)
+	}
+}
+
+func TestLayeredOptionalSection(t *testing.T) {
+	key := []byte("b6c1cd0fe6e55f22fb483096822b5d1c")
+	SetKeyProvider(KeyProviderFunc(func() ([]byte, error) { return key, nil }))
+	defer SetKeyProvider(EnvKeyProvider{})
+	password, err := Encrypt([]byte("123456"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type node struct {
+		Name string `toml:"name"`
+		Next *node  `toml:"next"`
+	}
+	conf := &struct {
+		Mysql *struct {
+			Host     string `toml:"host"`
+			Port     int    `toml:"port" default:"3306"`
+			Password string `toml:"password"`
+		} `toml:"mysql"`
+		Redis *struct {
+			Host string `toml:"host" default:"127.0.0.1"`
+		} `toml:"redis"`
+		Node node `toml:"node"`
+	}{}
+	t.Setenv("TEST_MYSQL_PASSWORD", password)
+
+	c := &LayeredConfig{EnvPrefix: "TEST_", Args: []string{"-mysql.host=10.0.0.1", "-node.next.name=n2"}}
+	if err = c.LoadLayeredConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	// 只有 env, flag 设置了值的可选配置段会被创建, 未创建的配置段不设置默认值
+	if conf.Mysql == nil || conf.Mysql.Host != "10.0.0.1" || conf.Mysql.Password != "123456" || conf.Mysql.Port != 0 {
+		t.Fatalf("unexpected mysql:%+v", conf.Mysql)
+	}
+	// 自引用的结构体指针不展开, 对应的参数被忽略
+	if conf.Redis != nil || conf.Node.Next != nil {
+		t.Fatalf("unexpected config:%+v", conf)
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Decoder 配置解码器, 将配置内容解码到 v, v 必须是指针
+type Decoder interface {
+	Decode(data []byte, v interface{}) error
+}
+
+// DecoderFunc 函数形式的 Decoder
+type DecoderFunc func(data []byte, v interface{}) error
+
+func (f DecoderFunc) Decode(data []byte, v interface{}) error {
+	return f(data, v)
+}
+
+const (
+	FormatToml = "toml"
+	FormatYaml = "yaml"
+	FormatJson = "json"
+)
+
+var (
+	TomlDecoder Decoder = DecoderFunc(func(data []byte, v interface{}) error {
+		_, err := toml.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+		return err
+	})
+	YamlDecoder Decoder = DecoderFunc(func(data []byte, v interface{}) error {
+		return yaml.Unmarshal(data, v)
+	})
+	JsonDecoder Decoder = DecoderFunc(func(data []byte, v interface{}) error {
+		return json.Unmarshal(data, v)
+	})
+)
+
+var ErrUnknownFormat = errors.New("unknown config format")
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]Decoder{
+		FormatToml: TomlDecoder,
+		FormatYaml: YamlDecoder,
+		"yml":      YamlDecoder,
+		FormatJson: JsonDecoder,
+	}
+)
+
+// RegisterDecoder 注册自定义格式的解码器, 已存在的格式会被覆盖
+func RegisterDecoder(format string, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[strings.ToLower(format)] = d
+}
+
+// GetDecoder 获取配置解码器
+//
+// format 显式指定时优先使用, 否则按 name 的后缀(文件扩展名、nacos dataId 或 consul key 的后缀)选择,
+// 后缀无法识别时使用 toml
+func GetDecoder(format, name string) (Decoder, error) {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	if len(format) != 0 {
+		if d, ok := decoders[strings.ToLower(format)]; ok {
+			return d, nil
+		}
+		return nil, ErrUnknownFormat
+	}
+
+	if d, ok := decoders[strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))]; ok {
+		return d, nil
+	}
+	return TomlDecoder, nil
+}
+
+// Encoder 配置编码器, 将 v 编码为配置内容, 用于 Publish 将配置写回配置中心
+type Encoder interface {
+	Encode(v interface{}) ([]byte, error)
+}
+
+// EncoderFunc 函数形式的 Encoder
+type EncoderFunc func(v interface{}) ([]byte, error)
+
+func (f EncoderFunc) Encode(v interface{}) ([]byte, error) {
+	return f(v)
+}
+
+var (
+	TomlEncoder Encoder = EncoderFunc(func(v interface{}) ([]byte, error) {
+		buf := &bytes.Buffer{}
+		if err := toml.NewEncoder(buf).Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	})
+	YamlEncoder Encoder = EncoderFunc(func(v interface{}) ([]byte, error) {
+		return yaml.Marshal(v)
+	})
+	JsonEncoder Encoder = EncoderFunc(func(v interface{}) ([]byte, error) {
+		return json.MarshalIndent(v, "", "  ")
+	})
+)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]Encoder{
+		FormatToml: TomlEncoder,
+		FormatYaml: YamlEncoder,
+		"yml":      YamlEncoder,
+		FormatJson: JsonEncoder,
+	}
+)
+
+// RegisterEncoder 注册自定义格式的编码器, 已存在的格式会被覆盖
+func RegisterEncoder(format string, e Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[strings.ToLower(format)] = e
+}
+
+// GetEncoder 获取配置编码器, format 与 name 的规则同 GetDecoder
+func GetEncoder(format, name string) (Encoder, error) {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	if len(format) != 0 {
+		if e, ok := encoders[strings.ToLower(format)]; ok {
+			return e, nil
+		}
+		return nil, ErrUnknownFormat
+	}
+
+	if e, ok := encoders[strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))]; ok {
+		return e, nil
+	}
+	return TomlEncoder, nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ChangedKeys 发生变化的配置 key 路径, 以 "." 分隔各层级, 如 redis.host
+type ChangedKeys []string
+
+// Has 判断 prefix 对应的配置项或配置段是否发生变化, 如 Has("redis") 在 redis.host 变化时返回 true
+func (k ChangedKeys) Has(prefix string) bool {
+	for _, key := range k {
+		if key == prefix || strings.HasPrefix(key, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// diffKeys 对比新旧两份配置, 返回发生变化的 key 路径(已排序)
+func diffKeys(old, new interface{}) ChangedKeys {
+	oldFlat, newFlat := make(map[string]interface{}), make(map[string]interface{})
+	flatten("", reflect.ValueOf(old), oldFlat)
+	flatten("", reflect.ValueOf(new), newFlat)
+
+	changed := make(ChangedKeys, 0)
+	for key, ov := range oldFlat {
+		if nv, ok := newFlat[key]; !ok || !reflect.DeepEqual(ov, nv) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range newFlat {
+		if _, ok := oldFlat[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// flatten 将 struct/map 展开为 key 路径 -> 叶子节点值
+func flatten(prefix string, v reflect.Value, out map[string]interface{}) {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			if len(prefix) != 0 {
+				out[prefix] = nil
+			}
+			return
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct && !v.Type().Implements(textMarshalerType):
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := fieldName(f)
+			if len(f.PkgPath) != 0 || name == "-" {
+				continue
+			}
+			flatten(joinKey(prefix, name), v.Field(i), out)
+		}
+	case v.Kind() == reflect.Map:
+		for _, mk := range v.MapKeys() {
+			flatten(joinKey(prefix, fmt.Sprint(mk.Interface())), v.MapIndex(mk), out)
+		}
+	default:
+		if len(prefix) != 0 {
+			out[prefix] = v.Interface()
+		}
+	}
+}
+
+// fieldName 获取结构体字段对应的配置 key, 依次使用 toml、yaml、json tag, 均未设置时为字段名
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{FormatToml, FormatYaml, FormatJson} {
+		if tag, ok := f.Tag.Lookup(key); ok {
+			if name := strings.Split(tag, ",")[0]; len(name) != 0 {
+				return name
+			}
+		}
+	}
+	return f.Name
+}
+
+func joinKey(prefix, key string) string {
+	if len(prefix) == 0 {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type EtcdConfig struct {
+	Client *clientv3.Client
+
+	Endpoints []string `json:"endpoints"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	Key       string   `json:"key"`
+	Format    string   `json:"format"`
+	// TimeoutMs 连接及查询 etcd 的超时时间, 为 0 时使用 DefaultEtcdTimeoutMs
+	TimeoutMs int `json:"timeoutMs"`
+	// SnapshotDir 本地快照目录, 为空时使用 DefaultSnapshotDir
+	SnapshotDir string `json:"snapshotDir"`
+	// DisableSnapshot 不保存本地快照, etcd 不可用时也不会使用快照启动
+	DisableSnapshot bool `json:"disableSnapshot"`
+
+	snapshot
+	mu       sync.Mutex
+	reloader *Reloader
+	revision int64
+	lastData []byte
+	listener listener
+}
+
+const DefaultEtcdTimeoutMs = 5000
+
+// LoadEtcdConfig 引导 etcd 配置数据给 conf
+func (c *EtcdConfig) LoadEtcdConfig(conf interface{}) (err error) {
+	decoder, err := c.decoder()
+	if err != nil {
+		logger.Errorf("etcd, unknown config format:%s of key:%s", c.Format, c.Key)
+		return err
+	}
+
+	content, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	if err = decode(decoder, content, conf); err != nil {
+		logger.Errorf("etcd, failed to decode content from endpoints:%s, key:%s, err:%s", c.endpoints(), c.Key, err.Error())
+		return err
+	}
+
+	if err = Validate(conf); err != nil {
+		logger.Errorf("etcd, %s, endpoints:%s, key:%s", err.Error(), c.endpoints(), c.Key)
+		return err
+	}
+	c.save(content)
+
+	logger.Infof("etcd, load config successful from endpoints:%s, key:%s", c.endpoints(), c.Key)
+	return nil
+}
+
+// Load 同 LoadEtcdConfig
+func (c *EtcdConfig) Load(conf interface{}) error {
+	return c.LoadEtcdConfig(conf)
+}
+
+func (c *EtcdConfig) decoder() (Decoder, error) {
+	return GetDecoder(c.Format, c.Key)
+}
+
+func (c *EtcdConfig) endpoints() string {
+	return strings.Join(c.Endpoints, ",")
+}
+
+func (c *EtcdConfig) timeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return time.Duration(DefaultEtcdTimeoutMs) * time.Millisecond
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// load 获取配置内容, etcd 不可用时使用本地快照中的旧配置启动, 并在后台重试直到 etcd 恢复
+func (c *EtcdConfig) load() (content []byte, err error) {
+	// 配置被删除或为空时不使用快照, 快照只用于配置中心不可用的情况
+	if content, err = c.fetch(); err == nil || err == ErrAddrIsEmpty || err == ErrContentIsEmpty {
+		return content, err
+	}
+
+	path := snapshotPath(c.SnapshotDir, c.DisableSnapshot, "etcd", c.Key)
+	stale, serr := readSnapshot(path)
+	if serr != nil {
+		return nil, err
+	}
+
+	logger.Warnf("etcd, running with stale config from snapshot:%s, endpoints:%s, key:%s, err:%s", path, c.endpoints(), c.Key, err.Error())
+	c.mu.Lock()
+	c.lastData = stale
+	c.mu.Unlock()
+	c.setStale(true)
+	c.recover(stale, c.fetch, func(content []byte) {
+		logger.Infof("etcd, recovered from stale config, endpoints:%s, key:%s", c.endpoints(), c.Key)
+		c.apply(content)
+	})
+	return stale, nil
+}
+
+// apply 将获取到的新配置交给 WatchConfig 注册的 Reloader
+func (c *EtcdConfig) apply(content []byte) {
+	c.mu.Lock()
+	r := c.reloader
+	c.mu.Unlock()
+
+	if r == nil {
+		logger.Warnf("etcd, config differs from snapshot but is not watched, use WatchConfig to apply it at runtime, key:%s", c.Key)
+		return
+	}
+	decoder, err := c.decoder()
+	if err == nil {
+		err = r.ReloadWith(decoder, content)
+	}
+	if err != nil {
+		logger.Errorf("etcd, failed to reload config from endpoints:%s, key:%s, err:%s", c.endpoints(), c.Key, err.Error())
+		return
+	}
+	c.save(content)
+}
+
+// save 保存本地快照, 只在配置解码、校验通过或热更新成功后调用, 避免错误的配置覆盖快照
+func (c *EtcdConfig) save(content []byte) {
+	saveSnapshot(snapshotPath(c.SnapshotDir, c.DisableSnapshot, "etcd", c.Key), content)
+}
+
+// fetch 获取 etcd 配置内容, 客户端不存在时先创建客户端, 获取成功后记录 revision 供 WatchConfig 使用
+func (c *EtcdConfig) fetch() (content []byte, err error) {
+	if len(c.Endpoints) == 0 {
+		return nil, ErrAddrIsEmpty
+	}
+
+	if c.Client == nil {
+		c.Client, err = clientv3.New(clientv3.Config{
+			Endpoints:   c.Endpoints,
+			Username:    c.Username,
+			Password:    c.Password,
+			DialTimeout: c.timeout(),
+		})
+		if err != nil {
+			logger.Errorf("failed create etcd:%s client, err:%s", c.endpoints(), err.Error())
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout())
+	defer cancel()
+	resp, err := c.Client.Get(ctx, c.Key)
+	if err != nil {
+		logger.Errorf("etcd, failed to get config content from endpoints:%s, key:%s, err:%s", c.endpoints(), c.Key, err.Error())
+		return nil, err
+	}
+
+	if len(resp.Kvs) == 0 || len(resp.Kvs[0].Value) == 0 {
+		logger.Errorf("etcd, config content is empty from endpoints:%s, key:%s", c.endpoints(), c.Key)
+		return nil, ErrContentIsEmpty
+	}
+
+	content = resp.Kvs[0].Value
+	c.mu.Lock()
+	c.revision, c.lastData = resp.Header.Revision, content
+	c.mu.Unlock()
+	return content, nil
+}
+
+// WatchConfig 配置监听, 变更的配置由 r 解码到新的副本, 校验通过后原子替换并通知 r 上注册的回调
+func (c *EtcdConfig) WatchConfig(r *Reloader) (err error) {
+	if c.Client == nil {
+		return ErrClientNotInit
+	}
+
+	decoder, err := c.decoder()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	revision := c.revision
+	if err = c.listener.start(func(ctx context.Context) {
+		c.watch(ctx, revision, func(data []byte) {
+			if err := r.ReloadWith(decoder, data); err != nil {
+				logger.Errorf("etcd, failed to reload config from endpoints:%s, key:%s, err:%s", c.endpoints(), c.Key, err.Error())
+				return
+			}
+			// 保存变更后的配置, 配置中心不可用时重启也能使用最新的配置
+			c.save(data)
+		})
+	}); err != nil {
+		return err
+	}
+	c.reloader = r
+	return nil
+}
+
+// CancelListenConfig 取消配置监听并停止快照恢复的重试, 会等待监听协程退出后返回
+func (c *EtcdConfig) CancelListenConfig() (err error) {
+	c.stopRecover()
+	c.listener.stop()
+	return nil
+}
+
+// watch 从 revision 之后开始监听 Key, 数据变化时调用 onChange,
+// 监听中断(如 revision 已被压缩)时按指数退避重新获取配置, 再从最新的 revision 继续监听, 直到 ctx 取消
+func (c *EtcdConfig) watch(ctx context.Context, revision int64, onChange func(data []byte)) {
+	backoff := retryMinBackoff
+	for {
+		// 每次监听使用独立的 ctx, 中断后取消, 避免废弃的 watcher 堆积在共享的 grpc stream 上
+		wctx, cancel := context.WithCancel(ctx)
+		wch := c.Client.Watch(clientv3.WithRequireLeader(wctx), c.Key, clientv3.WithRev(revision+1))
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				logger.Errorf("etcd, failed to watch config from endpoints:%s, key:%s, err:%s", c.endpoints(), c.Key, err.Error())
+				break
+			}
+			backoff = retryMinBackoff
+			for _, ev := range resp.Events {
+				revision = ev.Kv.ModRevision
+				if ev.Type == clientv3.EventTypeDelete {
+					logger.Warnf("etcd, config content is deleted from endpoints:%s, key:%s", c.endpoints(), c.Key)
+					continue
+				}
+				c.onData(ev.Kv.Value, onChange)
+			}
+		}
+		cancel()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > retryMaxBackoff {
+				backoff = retryMaxBackoff
+			}
+
+			c.mu.Lock()
+			lastData := c.lastData
+			c.mu.Unlock()
+			content, err := c.fetch()
+			if err == nil {
+				c.mu.Lock()
+				revision = c.revision
+				c.mu.Unlock()
+				if !bytes.Equal(content, lastData) {
+					onChange(content)
+				}
+				break
+			}
+		}
+	}
+}
+
+func (c *EtcdConfig) onData(data []byte, onChange func(data []byte)) {
+	c.mu.Lock()
+	changed := !bytes.Equal(data, c.lastData)
+	c.lastData = data
+	c.mu.Unlock()
+
+	if changed {
+		onChange(data)
+	}
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// HttpConfig 以 HTTP GET 的方式获取配置, 适用于 S3、网关后的静态配置文件等,
+// 轮询时携带 If-None-Match、If-Modified-Since 请求头, 服务端返回 304 时视为配置未变化
+type HttpConfig struct {
+	Client *http.Client
+
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Format  string            `json:"format"`
+	// IntervalMs 轮询间隔, 为 0 时使用 DefaultHttpIntervalMs
+	IntervalMs int `json:"intervalMs"`
+	// TimeoutMs 请求超时时间, 为 0 时使用 DefaultHttpTimeoutMs
+	TimeoutMs int `json:"timeoutMs"`
+	// SnapshotDir 本地快照目录, 为空时使用 DefaultSnapshotDir
+	SnapshotDir string `json:"snapshotDir"`
+	// DisableSnapshot 不保存本地快照, 配置地址不可用时也不会使用快照启动
+	DisableSnapshot bool `json:"disableSnapshot"`
+
+	snapshot
+	mu           sync.Mutex
+	reloader     *Reloader
+	etag         string
+	lastModified string
+	lastData     []byte
+	listener     listener
+}
+
+const (
+	DefaultHttpIntervalMs = 30000
+	DefaultHttpTimeoutMs  = 5000
+)
+
+// errNotModified 服务端返回 304, 配置未变化
+var errNotModified = errors.New("config is not modified")
+
+// LoadHttpConfig 引导 Url 对应的配置数据给 conf
+func (c *HttpConfig) LoadHttpConfig(conf interface{}) (err error) {
+	decoder, err := c.decoder()
+	if err != nil {
+		logger.Errorf("http, unknown config format:%s of url:%s", c.Format, c.Url)
+		return err
+	}
+
+	content, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	if err = decode(decoder, content, conf); err != nil {
+		logger.Errorf("http, failed to decode content from url:%s, err:%s", c.Url, err.Error())
+		return err
+	}
+
+	if err = Validate(conf); err != nil {
+		logger.Errorf("http, %s, url:%s", err.Error(), c.Url)
+		return err
+	}
+	c.save(content)
+
+	logger.Infof("http, load config successful from url:%s", c.Url)
+	return nil
+}
+
+// Load 同 LoadHttpConfig
+func (c *HttpConfig) Load(conf interface{}) error {
+	return c.LoadHttpConfig(conf)
+}
+
+// decoder Format 为空时按 Url 路径的扩展名选择解码格式
+func (c *HttpConfig) decoder() (Decoder, error) {
+	name := c.Url
+	if u, err := url.Parse(c.Url); err == nil {
+		name = path.Base(u.Path)
+	}
+	return GetDecoder(c.Format, name)
+}
+
+func (c *HttpConfig) interval() time.Duration {
+	if c.IntervalMs <= 0 {
+		return time.Duration(DefaultHttpIntervalMs) * time.Millisecond
+	}
+	return time.Duration(c.IntervalMs) * time.Millisecond
+}
+
+func (c *HttpConfig) timeout() time.Duration {
+	if c.TimeoutMs <= 0 {
+		return time.Duration(DefaultHttpTimeoutMs) * time.Millisecond
+	}
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// load 获取配置内容, 配置地址不可用时使用本地快照中的旧配置启动, 并在后台重试直到恢复,
+// LoadHttpConfig 与 LayeredConfig 均通过 load 加载
+func (c *HttpConfig) load() (content []byte, err error) {
+	// 在 recover, watch 协程启动前创建客户端, 之后只读
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: c.timeout()}
+	}
+
+	// 配置被删除或为空时不使用快照, 快照只用于配置中心不可用的情况
+	if content, err = c.fetch(); err == nil || err == ErrAddrIsEmpty || err == ErrContentIsEmpty {
+		return content, err
+	}
+
+	path := snapshotPath(c.SnapshotDir, c.DisableSnapshot, "http", c.Url)
+	stale, serr := readSnapshot(path)
+	if serr != nil {
+		return nil, err
+	}
+
+	logger.Warnf("http, running with stale config from snapshot:%s, url:%s, err:%s", path, c.Url, err.Error())
+	c.mu.Lock()
+	c.lastData = stale
+	c.mu.Unlock()
+	c.setStale(true)
+	c.recover(stale, c.fetch, func(content []byte) {
+		logger.Infof("http, recovered from stale config, url:%s", c.Url)
+		c.apply(content)
+	})
+	return stale, nil
+}
+
+// apply 将获取到的新配置交给 WatchConfig 注册的 Reloader
+func (c *HttpConfig) apply(content []byte) {
+	c.mu.Lock()
+	r := c.reloader
+	c.mu.Unlock()
+
+	if r == nil {
+		logger.Warnf("http, config differs from snapshot but is not watched, use WatchConfig to apply it at runtime, url:%s", c.Url)
+		return
+	}
+	decoder, err := c.decoder()
+	if err == nil {
+		err = r.ReloadWith(decoder, content)
+	}
+	if err != nil {
+		logger.Errorf("http, failed to reload config from url:%s, err:%s", c.Url, err.Error())
+		return
+	}
+	c.save(content)
+}
+
+// save 保存本地快照, 只在配置解码、校验通过或热更新成功后调用, 避免错误的配置覆盖快照
+func (c *HttpConfig) save(content []byte) {
+	saveSnapshot(snapshotPath(c.SnapshotDir, c.DisableSnapshot, "http", c.Url), content)
+}
+
+// fetch 不带条件请求头获取完整的配置内容
+func (c *HttpConfig) fetch() (content []byte, err error) {
+	return c.get(context.Background(), false)
+}
+
+// get 获取配置内容, Client 由 load 创建, 获取成功后记录 ETag 和 Last-Modified,
+// conditional 为 true 时携带条件请求头, 服务端返回 304 时返回 errNotModified
+func (c *HttpConfig) get(ctx context.Context, conditional bool) (content []byte, err error) {
+	if len(c.Url) == 0 {
+		return nil, ErrAddrIsEmpty
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Url, nil)
+	if err != nil {
+		logger.Errorf("http, failed to create request of url:%s, err:%s", c.Url, err.Error())
+		return nil, err
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if conditional {
+		c.mu.Lock()
+		etag, lastModified := c.etag, c.lastModified
+		c.mu.Unlock()
+		if len(etag) != 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if len(lastModified) != 0 {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		logger.Errorf("http, failed to get config content from url:%s, err:%s", c.Url, err.Error())
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	// 配置文件不存在或已被删除, 与内容为空一样处理
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		logger.Errorf("http, config content is not found from url:%s, status:%s", c.Url, resp.Status)
+		return nil, ErrContentIsEmpty
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorf("http, failed to get config content from url:%s, status:%s", c.Url, resp.Status)
+		return nil, fmt.Errorf("http, unexpected status %s", resp.Status)
+	}
+
+	if content, err = ioutil.ReadAll(resp.Body); err != nil {
+		logger.Errorf("http, failed to read config content from url:%s, err:%s", c.Url, err.Error())
+		return nil, err
+	}
+	if len(content) == 0 {
+		logger.Errorf("http, config content is empty from url:%s", c.Url)
+		return nil, ErrContentIsEmpty
+	}
+
+	c.mu.Lock()
+	c.etag, c.lastModified, c.lastData = resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), content
+	c.mu.Unlock()
+	return content, nil
+}
+
+// WatchConfig 配置监听, 每隔 IntervalMs 轮询一次 Url,
+// 变更的配置由 r 解码到新的副本, 校验通过后原子替换并通知 r 上注册的回调
+func (c *HttpConfig) WatchConfig(r *Reloader) (err error) {
+	if c.Client == nil {
+		return ErrClientNotInit
+	}
+
+	decoder, err := c.decoder()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = c.listener.start(func(ctx context.Context) {
+		c.watch(ctx, func(data []byte) {
+			if err := r.ReloadWith(decoder, data); err != nil {
+				logger.Errorf("http, failed to reload config from url:%s, err:%s", c.Url, err.Error())
+				return
+			}
+			c.save(data)
+		})
+	}); err != nil {
+		return err
+	}
+	c.reloader = r
+	return nil
+}
+
+// CancelListenConfig 取消配置监听并停止快照恢复的重试, 会等待监听协程退出后返回
+func (c *HttpConfig) CancelListenConfig() (err error) {
+	c.stopRecover()
+	c.listener.stop()
+	return nil
+}
+
+// watch 轮询 Url, 数据变化时调用 onChange, 出错后按指数退避重试(不小于轮询间隔), 直到 ctx 取消
+func (c *HttpConfig) watch(ctx context.Context, onChange func(data []byte)) {
+	wait := c.interval()
+	backoff := retryMinBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		c.mu.Lock()
+		lastData := c.lastData
+		c.mu.Unlock()
+
+		content, err := c.get(ctx, true)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err == errNotModified:
+			wait, backoff = c.interval(), retryMinBackoff
+		case err != nil:
+			// 退避间隔不小于轮询间隔, 出错时不会比正常轮询更频繁
+			if wait = backoff; wait < c.interval() {
+				wait = c.interval()
+			}
+			if backoff *= 2; backoff > retryMaxBackoff {
+				backoff = retryMaxBackoff
+			}
+		default:
+			wait, backoff = c.interval(), retryMinBackoff
+			if !bytes.Equal(content, lastData) {
+				onChange(content)
+			}
+		}
+	}
+}
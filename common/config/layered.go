@@ -0,0 +1,483 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// Layer 配置值的来源层, 优先级从低到高依次为 default, file, nacos, consul, etcd, http, env, flag
+type Layer string
+
+const (
+	LayerDefault Layer = "default"
+	LayerFile    Layer = "file"
+	LayerNacos   Layer = "nacos"
+	LayerConsul  Layer = "consul"
+	LayerEtcd    Layer = "etcd"
+	LayerHttp    Layer = "http"
+	LayerEnv     Layer = "env"
+	LayerFlag    Layer = "flag"
+)
+
+const DefaultEnvPrefix = "APP_"
+
+// LayeredConfig 分层配置加载器, 按优先级从低到高依次合并:
+//
+// 1. 默认值: conf 中已有的值, 以及字段上 `default:"..."` tag 指定的值(仅在字段为零值时生效)
+//
+// 2. File、Nacos、Consul、Etcd、Http 配置源(为 nil 的配置源会被跳过)
+//
+// 3. 环境变量: 默认名称为 EnvPrefix 加上大写下划线形式的 key 路径, 如 redis.maxIdle 对应 APP_REDIS_MAX_IDLE,
+// 字段可以通过 `env:"..."` tag 替换其所在层级的名称, `env:"-"` 表示不从环境变量读取
+//
+// 4. 命令行参数: 形如 -redis.host=127.0.0.1 或 --redis.host 127.0.0.1, 名称不区分大小写,
+// 字段可以通过 `flag:"..."` tag 替换其所在层级的名称, `flag:"-"` 表示不从命令行读取, 未知的参数会被忽略
+//
+// 全部合并完成后再按 Validate 校验
+type LayeredConfig struct {
+	File   *FileConfig   `json:"file"`
+	Nacos  *NacosConfig  `json:"nacos"`
+	Consul *ConsulConfig `json:"consul"`
+	Etcd   *EtcdConfig   `json:"etcd"`
+	Http   *HttpConfig   `json:"http"`
+
+	// EnvPrefix 环境变量前缀, 为空时使用 DefaultEnvPrefix
+	EnvPrefix string `json:"envPrefix"`
+	// Args 命令行参数, 为 nil 时使用 os.Args[1:]
+	Args []string `json:"-"`
+
+	origins map[string]Layer
+}
+
+var ErrConfNotStruct = errors.New("config conf must be a non-nil pointer to struct")
+
+// leafField 配置结构体中的叶子字段, 所在的结构体指针可能为 nil, 通过 get, alloc 获取字段的值
+type leafField struct {
+	path  string
+	env   string
+	flag  string
+	field reflect.StructField
+	root  reflect.Value
+	index []int
+}
+
+// get 获取字段的值, 所在的结构体指针为 nil 时返回 false
+func (l leafField) get() (reflect.Value, bool) {
+	v := l.root
+	for i, idx := range l.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// alloc 获取字段的值, 所在的结构体指针为 nil 时先创建, 用于 env, flag 设置未配置的可选配置段
+func (l leafField) alloc() reflect.Value {
+	v := l.root
+	for i, idx := range l.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v
+}
+
+// LoadLayeredConfig 分层引导配置数据给 conf, conf 必须是结构体指针
+func (c *LayeredConfig) LoadLayeredConfig(conf interface{}) (err error) {
+	rv := reflect.ValueOf(conf)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrConfNotStruct
+	}
+
+	c.origins = make(map[string]Layer)
+	for _, leaf := range leafFields(rv.Elem()) {
+		c.origins[leaf.path] = LayerDefault
+		// 为 nil 的可选配置段不设置默认值
+		if def, ok := leaf.field.Tag.Lookup("default"); ok {
+			v, exist := leaf.get()
+			if !exist || !v.IsZero() {
+				continue
+			}
+			if err = setFromString(v, def); err != nil {
+				return fmt.Errorf("config default value of %s is invalid: %w", leaf.path, err)
+			}
+		}
+	}
+
+	// 配置中心的快照在全部配置校验通过后再保存, 避免错误的配置覆盖快照
+	var snapshots []func()
+
+	if c.File != nil {
+		decoder, err := GetDecoder(c.File.Format, c.File.Path)
+		if err != nil {
+			return err
+		}
+		content, err := c.File.read()
+		if err != nil {
+			return err
+		}
+		if err = c.merge(rv, decoder, content, LayerFile); err != nil {
+			logger.Errorf("layered, failed to decode content from local file:%s, err:%s", c.File.Path, err.Error())
+			return err
+		}
+	}
+
+	if c.Nacos != nil {
+		decoder, err := GetDecoder(c.Nacos.Format, c.Nacos.DataId)
+		if err != nil {
+			return err
+		}
+		content, err := c.Nacos.load()
+		if err != nil {
+			return err
+		}
+		if err = c.merge(rv, decoder, content, LayerNacos); err != nil {
+			logger.Errorf("layered, failed to decode content from nacos addr:%s, dataId:%s, err:%s", c.Nacos.Addr, c.Nacos.DataId, err.Error())
+			return err
+		}
+		snapshots = append(snapshots, func() { c.Nacos.save(content) })
+	}
+
+	if c.Consul != nil {
+		decoder, err := GetDecoder(c.Consul.Format, c.Consul.ServiceId)
+		if err != nil {
+			return err
+		}
+		content, err := c.Consul.load()
+		if err != nil {
+			return err
+		}
+		if err = c.merge(rv, decoder, content, LayerConsul); err != nil {
+			logger.Errorf("layered, failed to decode content from consul addr:%s, serviceId:%s, err:%s", c.Consul.Addr, c.Consul.ServiceId, err.Error())
+			return err
+		}
+		snapshots = append(snapshots, func() { c.Consul.save(content) })
+	}
+
+	if c.Etcd != nil {
+		decoder, err := c.Etcd.decoder()
+		if err != nil {
+			return err
+		}
+		content, err := c.Etcd.load()
+		if err != nil {
+			return err
+		}
+		if err = c.merge(rv, decoder, content, LayerEtcd); err != nil {
+			logger.Errorf("layered, failed to decode content from etcd endpoints:%s, key:%s, err:%s", c.Etcd.endpoints(), c.Etcd.Key, err.Error())
+			return err
+		}
+		snapshots = append(snapshots, func() { c.Etcd.save(content) })
+	}
+
+	if c.Http != nil {
+		decoder, err := c.Http.decoder()
+		if err != nil {
+			return err
+		}
+		content, err := c.Http.load()
+		if err != nil {
+			return err
+		}
+		if err = c.merge(rv, decoder, content, LayerHttp); err != nil {
+			logger.Errorf("layered, failed to decode content from url:%s, err:%s", c.Http.Url, err.Error())
+			return err
+		}
+		snapshots = append(snapshots, func() { c.Http.save(content) })
+	}
+
+	if err = c.applyEnv(rv); err != nil {
+		return err
+	}
+	if err = c.applyFlags(rv); err != nil {
+		return err
+	}
+	// env, flag 中的 ENC(...) 值与配置源一样需要解密
+	if err = (&secretWalker{}).walk(rv, ""); err != nil {
+		logger.Errorf("layered, %s", err.Error())
+		return err
+	}
+
+	if err = Validate(conf); err != nil {
+		logger.Errorf("layered, %s", err.Error())
+		return err
+	}
+
+	for _, save := range snapshots {
+		save()
+	}
+	logger.Infof("layered, load config successful, origins:%v", c.origins)
+	return nil
+}
+
+// Origins 获取上一次 LoadLayeredConfig 后每个配置 key 路径最终生效值的来源层
+func (c *LayeredConfig) Origins() map[string]Layer {
+	origins := make(map[string]Layer, len(c.origins))
+	for k, v := range c.origins {
+		origins[k] = v
+	}
+	return origins
+}
+
+// merge 将配置内容解码到 conf, 并将内容中出现的 key 标记为来自 layer
+func (c *LayeredConfig) merge(rv reflect.Value, decoder Decoder, content []byte, layer Layer) (err error) {
+	if err = decode(decoder, content, rv.Interface()); err != nil {
+		return err
+	}
+
+	raw := make(map[string]interface{})
+	if err = decoder.Decode(content, &raw); err != nil {
+		return err
+	}
+	flat := make(map[string]interface{})
+	flatten("", reflect.ValueOf(raw), flat)
+
+	leaves := leafFields(rv.Elem())
+	for key := range flat {
+		key = strings.ToLower(key)
+		for _, leaf := range leaves {
+			path := strings.ToLower(leaf.path)
+			if key == path || strings.HasPrefix(key, path+".") {
+				c.origins[leaf.path] = layer
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (c *LayeredConfig) applyEnv(rv reflect.Value) (err error) {
+	prefix := c.EnvPrefix
+	if len(prefix) == 0 {
+		prefix = DefaultEnvPrefix
+	}
+
+	for _, leaf := range leafFields(rv.Elem()) {
+		if len(leaf.env) == 0 {
+			continue
+		}
+		name := prefix + leaf.env
+		if value, ok := os.LookupEnv(name); ok {
+			if err = setFromString(leaf.alloc(), value); err != nil {
+				return fmt.Errorf("config env %s is invalid: %w", name, err)
+			}
+			c.origins[leaf.path] = LayerEnv
+		}
+	}
+	return nil
+}
+
+func (c *LayeredConfig) applyFlags(rv reflect.Value) (err error) {
+	args := c.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+
+	leaves := make(map[string]leafField)
+	for _, leaf := range leafFields(rv.Elem()) {
+		if len(leaf.flag) != 0 {
+			leaves[strings.ToLower(leaf.flag)] = leaf
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		leaf, ok := leaves[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		if !hasValue {
+			switch {
+			case indirectType(leaf.field.Type).Kind() == reflect.Bool:
+				value = "true"
+			case i+1 < len(args):
+				i++
+				value = args[i]
+			default:
+				return fmt.Errorf("config flag -%s needs a value", name)
+			}
+		}
+
+		if err = setFromString(leaf.alloc(), value); err != nil {
+			return fmt.Errorf("config flag -%s is invalid: %w", name, err)
+		}
+		c.origins[leaf.path] = LayerFlag
+	}
+	return nil
+}
+
+// leafFields 遍历结构体中的叶子字段, 嵌套结构体及结构体指针(包括 nil)会被展开
+func leafFields(v reflect.Value) []leafField {
+	leaves := make([]leafField, 0)
+	walking := map[reflect.Type]bool{v.Type(): true}
+	var walk func(t reflect.Type, index []int, path, env, flag string)
+	walk = func(t reflect.Type, index []int, path, env, flag string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := fieldName(f)
+			if len(f.PkgPath) != 0 || name == "-" {
+				continue
+			}
+
+			leaf := leafField{path: joinKey(path, name), field: f, root: v, index: append(append([]int{}, index...), i)}
+			leaf.env = joinSegment(env, f.Tag.Get("env"), toEnvName(name), "_")
+			leaf.flag = joinSegment(flag, f.Tag.Get("flag"), name, ".")
+
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !isScalar(ft) {
+				// 自引用的结构体指针不再展开
+				if !walking[ft] {
+					walking[ft] = true
+					walk(ft, leaf.index, leaf.path, leaf.env, leaf.flag)
+					walking[ft] = false
+				}
+				continue
+			}
+			if leaf.env == "-" {
+				leaf.env = ""
+			}
+			if leaf.flag == "-" {
+				leaf.flag = ""
+			}
+			leaves = append(leaves, leaf)
+		}
+	}
+	walk(v.Type(), nil, "", "", "")
+	return leaves
+}
+
+// joinSegment 拼接 env/flag 名称, tag 为 "-" 或上级已被禁用时返回 "-"
+func joinSegment(parent, tag, name, sep string) string {
+	if tag == "-" || parent == "-" {
+		return "-"
+	}
+	if len(tag) != 0 {
+		name = tag
+	}
+	if len(parent) == 0 {
+		return name
+	}
+	return parent + sep + name
+}
+
+// toEnvName 将驼峰命名转换为大写下划线命名, 如 maxIdleConn 转换为 MAX_IDLE_CONN
+func toEnvName(name string) string {
+	var b strings.Builder
+	runes := []rune(strings.ReplaceAll(name, "-", "_"))
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isScalar 判断结构体是否应作为单个值处理, 如 time.Time
+func isScalar(t reflect.Type) bool {
+	return t.Implements(textUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// setFromString 将字符串形式的值解析后赋给 v
+func setFromString(v reflect.Value, s string) (err error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setFromString(v.Elem(), s)
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == durationType {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		// 切片以逗号分隔, 如 APP_KAFKA_TOPICS=a,b,c
+		parts := strings.Split(s, ",")
+		slice := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err = setFromString(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	default:
+		return fmt.Errorf("unsupported config value type:%s", v.Type())
+	}
+	return nil
+}
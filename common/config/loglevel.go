@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// LogLevelConf 日志级别配置, 嵌入到应用配置中后可以通过 WatchLogLevel 在配置变更时修改日志级别, 如:
+//
+//	type AppConf struct {
+//		config.LogLevelConf `yaml:",inline"`
+//		AppName string `toml:"appName" yaml:"appName"`
+//	}
+//
+// 对应的 toml 配置:
+//
+//	logLevel = "info"
+//	[logLevels]
+//	kafka = "debug"
+type LogLevelConf struct {
+	// LogLevel 全局日志级别, 为空时不修改
+	LogLevel string `toml:"logLevel" yaml:"logLevel" json:"logLevel" validate:"omitempty,oneof=debug info warn error dpanic panic fatal"`
+	// LogLevels 按 logger.Named 名称覆盖的日志级别, 配置中删除的名称恢复使用全局级别
+	LogLevels map[string]string `toml:"logLevels" yaml:"logLevels" json:"logLevels" validate:"omitempty,dive,oneof=debug info warn error dpanic panic fatal"`
+}
+
+func (c *LogLevelConf) GetLogLevelConf() *LogLevelConf {
+	return c
+}
+
+type logLevelConfGetter interface {
+	GetLogLevelConf() *LogLevelConf
+}
+
+var ErrNoLogLevelConf = errors.New("config conf does not embed config.LogLevelConf")
+
+// WatchLogLevel 使用 r 当前配置中的 LogLevelConf 修改日志级别, 之后每次配置变更(如 nacos 配置修改)时重新应用,
+// r 的配置需要嵌入 LogLevelConf
+func WatchLogLevel(r *Reloader) error {
+	getter, ok := r.Get().(logLevelConfGetter)
+	if !ok {
+		return ErrNoLogLevelConf
+	}
+	applyLogLevel(nil, getter.GetLogLevelConf())
+
+	r.OnChange(func(old, new interface{}, changed ChangedKeys) {
+		var prev *LogLevelConf
+		if getter, ok := old.(logLevelConfGetter); ok {
+			prev = getter.GetLogLevelConf()
+		}
+		if getter, ok := new.(logLevelConfGetter); ok {
+			applyLogLevel(prev, getter.GetLogLevelConf())
+		}
+	})
+	return nil
+}
+
+// applyLogLevel 应用 conf 中的日志级别, 与 prev 相同时不做修改
+func applyLogLevel(prev, conf *LogLevelConf) {
+	if prev != nil && reflect.DeepEqual(*prev, *conf) {
+		return
+	}
+
+	if len(conf.LogLevel) != 0 {
+		level, err := logger.ParseLevel(conf.LogLevel)
+		if err != nil {
+			logger.Errorf("log level, failed to parse logLevel:%s, err:%s", conf.LogLevel, err.Error())
+		} else {
+			logger.SetLevel(level)
+		}
+	}
+
+	named := make(map[string]zapcore.Level, len(conf.LogLevels))
+	for name, text := range conf.LogLevels {
+		level, err := logger.ParseLevel(text)
+		if err != nil {
+			logger.Errorf("log level, failed to parse logLevels.%s:%s, err:%s", name, text, err.Error())
+			continue
+		}
+		named[name] = level
+	}
+	logger.SetNamedLevels(named)
+	logger.Infof("log level, apply logLevel:%s, logLevels:%v", logger.GetLevel(), conf.LogLevels)
+}
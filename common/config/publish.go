@@ -0,0 +1,419 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+	"github.com/lethexixin/go-funcs/utils/cryptos"
+)
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+)
+
+// 发布配置: Publish 将配置编码后以 CAS 的方式写回配置中心, 配置在上一次读取后被他人修改时返回 ErrPublishConflict,
+// 此时需要重新 Load 后再修改发布. consul 比较 ModifyIndex, nacos 比较 MD5.
+//
+// 每次发布成功后, 发布的内容会记录到配置中心中 key(consul ServiceId 或 nacos DataId) 加上 .history 后缀的历史版本中,
+// 可以通过 History 查看, Rollback 回滚
+//
+// conf 中由 ENC(...) 解密得到的值, 若发布时未被修改, 会保留配置中心中原有的 ENC(...) 加密值, 不会以明文写回;
+// 新增或修改的敏感值需先用 Encrypt 加密后再发布
+
+const (
+	// DefaultHistoryLimit 默认保留的历史版本数
+	DefaultHistoryLimit = 10
+
+	historySuffix = ".history"
+)
+
+var (
+	ErrPublishConflict  = errors.New("config has been modified since last load")
+	ErrRevisionNotFound = errors.New("config revision not found")
+)
+
+// Revision 配置的历史版本
+type Revision struct {
+	Version int64     `json:"version"`
+	Md5     string    `json:"md5"`
+	Time    time.Time `json:"time"`
+	Content string    `json:"content"`
+}
+
+// encodeConf 使用 encoder 编码 conf, 并将未修改的敏感值替换回 current 中的 ENC(...) 加密值
+func encodeConf(encoder Encoder, decoder Decoder, conf interface{}, current []byte) (content []byte, err error) {
+	if content, err = encoder.Encode(conf); err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(current, []byte(secretPrefix)) {
+		return content, nil
+	}
+
+	typ := reflect.TypeOf(conf)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	old, new := reflect.New(typ), reflect.New(typ)
+	if err = decoder.Decode(current, old.Interface()); err != nil {
+		return nil, err
+	}
+	if err = decoder.Decode(content, new.Interface()); err != nil {
+		return nil, err
+	}
+	if err = (&secretWalker{}).reseal(old.Elem(), new.Elem(), ""); err != nil {
+		return nil, err
+	}
+	return encoder.Encode(new.Interface())
+}
+
+// reseal 对比原配置 old 与新配置 new, new 中与 old 的 ENC(...) 解密结果相同的值替换回加密值
+func (s *secretWalker) reseal(old, new reflect.Value, path string) (err error) {
+	if old.Kind() != new.Kind() {
+		return nil
+	}
+
+	switch new.Kind() {
+	case reflect.Ptr:
+		if !old.IsNil() && !new.IsNil() {
+			return s.reseal(old.Elem(), new.Elem(), path)
+		}
+	case reflect.Interface:
+		if old.IsNil() || new.IsNil() || !new.CanSet() {
+			return nil
+		}
+		elem := reflect.New(new.Elem().Type()).Elem()
+		elem.Set(new.Elem())
+		if err = s.reseal(old.Elem(), elem, path); err != nil {
+			return err
+		}
+		new.Set(elem)
+	case reflect.Struct:
+		t := new.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if len(t.Field(i).PkgPath) != 0 {
+				continue
+			}
+			if err = s.reseal(old.Field(i), new.Field(i), joinKey(path, fieldName(t.Field(i)))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range new.MapKeys() {
+			ov := old.MapIndex(k)
+			if !ov.IsValid() {
+				continue
+			}
+			elem := reflect.New(new.Type().Elem()).Elem()
+			elem.Set(new.MapIndex(k))
+			if err = s.reseal(ov, elem, joinKey(path, fmt.Sprint(k.Interface()))); err != nil {
+				return err
+			}
+			new.SetMapIndex(k, elem)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < new.Len() && i < old.Len(); i++ {
+			if err = s.reseal(old.Index(i), new.Index(i), path); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if !new.CanSet() || !isSecret(old.String()) || isSecret(new.String()) {
+			return nil
+		}
+		plaintext, err := s.decrypt(old.String(), path)
+		if err != nil {
+			return err
+		}
+		if plaintext == new.String() {
+			new.SetString(old.String())
+		}
+	}
+	return nil
+}
+
+// parseHistory 解析历史版本, 按版本号从新到旧排列
+func parseHistory(data []byte) (history []Revision, err error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err = json.Unmarshal(data, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// addRevision 将 content 记录为新的历史版本, 历史为空时先记录发布前的内容 current, 超过 limit 的旧版本会被删除
+func addRevision(history []Revision, current, content []byte, limit int) []Revision {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+
+	var version int64
+	if len(history) != 0 {
+		version = history[0].Version
+	}
+	now := time.Now()
+	revisions := make([]Revision, 0, 2)
+	if len(history) == 0 && len(current) != 0 {
+		version++
+		revisions = append(revisions, Revision{Version: version, Md5: cryptos.EncodeMD5(string(current)), Time: now, Content: string(current)})
+	}
+	version++
+	revisions = append(revisions, Revision{Version: version, Md5: cryptos.EncodeMD5(string(content)), Time: now, Content: string(content)})
+
+	for _, rev := range revisions {
+		history = append([]Revision{rev}, history...)
+	}
+	if len(history) > limit {
+		history = history[:limit]
+	}
+	return history
+}
+
+func findRevision(history []Revision, version int64) (Revision, error) {
+	for _, rev := range history {
+		if rev.Version == version {
+			return rev, nil
+		}
+	}
+	return Revision{}, ErrRevisionNotFound
+}
+
+// Publish 将 conf 编码后以 CAS 的方式发布到 consul, 比较的是上一次读取到的 ModifyIndex,
+// 未读取过配置时仅在 ServiceId 不存在时发布成功
+func (c *ConsulConfig) Publish(conf interface{}) (err error) {
+	if c.Client == nil {
+		return ErrClientNotInit
+	}
+
+	encoder, err := GetEncoder(c.Format, c.ServiceId)
+	if err != nil {
+		return err
+	}
+	decoder, err := GetDecoder(c.Format, c.ServiceId)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	index, current := c.modifyIndex, c.lastData
+	c.mu.Unlock()
+
+	content, err := encodeConf(encoder, decoder, conf, current)
+	if err != nil {
+		logger.Errorf("consul, failed to encode config of serviceId:%s, err:%s", c.ServiceId, err.Error())
+		return err
+	}
+	return c.publish(index, current, content)
+}
+
+// History 获取 Publish 记录的历史版本, 按版本号从新到旧排列
+func (c *ConsulConfig) History() ([]Revision, error) {
+	if c.Client == nil {
+		return nil, ErrClientNotInit
+	}
+
+	pair, _, err := c.Client.KV().Get(c.ServiceId+historySuffix, nil)
+	if err != nil {
+		logger.Errorf("consul, failed to get config history from addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+		return nil, err
+	}
+	if pair == nil {
+		return nil, nil
+	}
+	return parseHistory(pair.Value)
+}
+
+// Rollback 将配置回滚到历史版本 version 的内容, 回滚同样以 CAS 的方式发布, 并记录为新的历史版本
+func (c *ConsulConfig) Rollback(version int64) (err error) {
+	history, err := c.History()
+	if err != nil {
+		return err
+	}
+	rev, err := findRevision(history, version)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	index, current := c.modifyIndex, c.lastData
+	c.mu.Unlock()
+	return c.publish(index, current, []byte(rev.Content))
+}
+
+func (c *ConsulConfig) publish(index uint64, current, content []byte) (err error) {
+	ok, _, err := c.Client.KV().CAS(&api.KVPair{Key: c.ServiceId, Value: content, ModifyIndex: index}, nil)
+	if err != nil {
+		logger.Errorf("consul, failed to publish config to addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+		return err
+	}
+	if !ok {
+		logger.Warnf("consul, config is modified since last load, addr:%s, serviceId:%s, index:%d", c.Addr, c.ServiceId, index)
+		return ErrPublishConflict
+	}
+	logger.Infof("consul, publish config successful to addr:%s, serviceId:%s", c.Addr, c.ServiceId)
+
+	// 重新读取以获取发布后的 ModifyIndex
+	if _, err = c.fetch(); err != nil {
+		return err
+	}
+	if err = c.saveRevision(current, content); err != nil {
+		logger.Errorf("consul, failed to save config history to addr:%s, serviceId:%s, err:%s", c.Addr, c.ServiceId, err.Error())
+	}
+	return nil
+}
+
+func (c *ConsulConfig) saveRevision(current, content []byte) (err error) {
+	key := c.ServiceId + historySuffix
+	pair, _, err := c.Client.KV().Get(key, nil)
+	if err != nil {
+		return err
+	}
+
+	var (
+		index   uint64
+		history []Revision
+	)
+	if pair != nil {
+		if history, err = parseHistory(pair.Value); err != nil {
+			return err
+		}
+		index = pair.ModifyIndex
+	}
+
+	data, err := json.Marshal(addRevision(history, current, content, c.HistoryLimit))
+	if err != nil {
+		return err
+	}
+	ok, _, err := c.Client.KV().CAS(&api.KVPair{Key: key, Value: data, ModifyIndex: index}, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPublishConflict
+	}
+	return nil
+}
+
+// Publish 将 conf 编码后以 CAS 的方式发布到 nacos, 比较的是上一次读取到的内容的 MD5,
+// 未读取过配置时直接发布
+func (c *NacosConfig) Publish(conf interface{}) (err error) {
+	if c.Client == nil {
+		return ErrClientNotInit
+	}
+
+	encoder, err := GetEncoder(c.Format, c.DataId)
+	if err != nil {
+		return err
+	}
+	decoder, err := GetDecoder(c.Format, c.DataId)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	current := c.lastData
+	c.mu.Unlock()
+
+	content, err := encodeConf(encoder, decoder, conf, current)
+	if err != nil {
+		logger.Errorf("nacos, failed to encode config of dataId:%s, group:%s, err:%s", c.DataId, c.Group, err.Error())
+		return err
+	}
+	return c.publish(current, content)
+}
+
+// History 获取 Publish 记录的历史版本, 按版本号从新到旧排列
+func (c *NacosConfig) History() ([]Revision, error) {
+	if c.Client == nil {
+		return nil, ErrClientNotInit
+	}
+
+	data, err := c.Client.GetConfig(vo.ConfigParam{DataId: c.DataId + historySuffix, Group: c.Group})
+	if err != nil {
+		logger.Errorf("nacos, failed to get config history from addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+		return nil, err
+	}
+	return parseHistory([]byte(data))
+}
+
+// Rollback 将配置回滚到历史版本 version 的内容, 回滚同样以 CAS 的方式发布, 并记录为新的历史版本
+func (c *NacosConfig) Rollback(version int64) (err error) {
+	history, err := c.History()
+	if err != nil {
+		return err
+	}
+	rev, err := findRevision(history, version)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	current := c.lastData
+	c.mu.Unlock()
+	return c.publish(current, []byte(rev.Content))
+}
+
+func (c *NacosConfig) publish(current, content []byte) (err error) {
+	var casMd5 string
+	if len(current) != 0 {
+		casMd5 = cryptos.EncodeMD5(string(current))
+	}
+
+	ok, err := c.Client.PublishConfig(vo.ConfigParam{DataId: c.DataId, Group: c.Group, Content: string(content), CasMd5: casMd5})
+	if err != nil {
+		logger.Errorf("nacos, failed to publish config to addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+		return err
+	}
+	if !ok {
+		logger.Warnf("nacos, config is modified since last load, addr:%s, namespaceId:%s, dataId:%s, group:%s, md5:%s", c.Addr, c.Namespace, c.DataId, c.Group, casMd5)
+		return ErrPublishConflict
+	}
+	logger.Infof("nacos, publish config successful to addr:%s, namespaceId:%s, dataId:%s, group:%s", c.Addr, c.Namespace, c.DataId, c.Group)
+
+	c.mu.Lock()
+	c.lastData = content
+	c.mu.Unlock()
+
+	if err = c.saveRevision(current, content); err != nil {
+		logger.Errorf("nacos, failed to save config history to addr:%s, namespaceId:%s, dataId:%s, group:%s, err:%s", c.Addr, c.Namespace, c.DataId, c.Group, err.Error())
+	}
+	return nil
+}
+
+func (c *NacosConfig) saveRevision(current, content []byte) (err error) {
+	param := vo.ConfigParam{DataId: c.DataId + historySuffix, Group: c.Group, Type: FormatJson}
+	data, err := c.Client.GetConfig(param)
+	if err != nil {
+		return err
+	}
+	history, err := parseHistory([]byte(data))
+	if err != nil {
+		return err
+	}
+
+	content, err = json.Marshal(addRevision(history, current, content, c.HistoryLimit))
+	if err != nil {
+		return err
+	}
+	if len(data) != 0 {
+		param.CasMd5 = cryptos.EncodeMD5(data)
+	}
+	param.Content = string(content)
+	ok, err := c.Client.PublishConfig(param)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPublishConflict
+	}
+	return nil
+}
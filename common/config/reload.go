@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// ChangeFunc 配置变更回调, old 为变更前的配置, new 为变更后的配置, changed 为发生变化的 key 路径
+type ChangeFunc func(old, new interface{}, changed ChangedKeys)
+
+// Reloader 配置热加载器
+//
+// 每次变更都会解码到一份新的配置副本, 经 Validate 及 Validator 校验通过后原子替换当前配置, 再依次通知注册的回调,
+// 正在读取旧配置的协程不会读到解码了一半的数据
+type Reloader struct {
+	mu        sync.Mutex // 串行化 Reload
+	value     atomic.Value
+	typ       reflect.Type
+	validator func(conf interface{}) error
+
+	cbMu      sync.RWMutex
+	callbacks []ChangeFunc
+}
+
+type ReloadOptions struct {
+	validator func(conf interface{}) error
+}
+
+type ReloadOption func(*ReloadOptions)
+
+var (
+	ErrConfNotPointer = errors.New("config conf must be a non-nil pointer")
+	ErrClientNotInit  = errors.New("config center client is not initialized, load config first")
+)
+
+// Validator 设置热加载配置的额外校验函数, 在 Validate 校验通过后执行, 校验失败的配置不会被替换
+func Validator(validator func(conf interface{}) error) ReloadOption {
+	return func(o *ReloadOptions) {
+		o.validator = validator
+	}
+}
+
+// NewReloader 以 conf 作为初始配置创建热加载器, conf 必须是非 nil 指针, 后续配置均为与 conf 同类型的新指针
+func NewReloader(conf interface{}, options ...ReloadOption) (*Reloader, error) {
+	rv := reflect.ValueOf(conf)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, ErrConfNotPointer
+	}
+
+	opts := ReloadOptions{}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	r := &Reloader{
+		typ:       rv.Elem().Type(),
+		validator: opts.validator,
+	}
+	r.value.Store(conf)
+	return r, nil
+}
+
+// Get 获取当前配置, 返回值与 NewReloader 传入的 conf 类型一致, 调用方不应修改返回的配置
+func (r *Reloader) Get() interface{} {
+	return r.value.Load()
+}
+
+// OnChange 注册配置变更回调, 回调按注册顺序在配置替换之后同步执行
+func (r *Reloader) OnChange(fn ChangeFunc) {
+	r.cbMu.Lock()
+	defer r.cbMu.Unlock()
+	r.callbacks = append(r.callbacks, fn)
+}
+
+// Reload 以 toml 格式解码配置数据到新的副本, 校验通过后替换当前配置, 配置有变化时通知回调
+func (r *Reloader) Reload(data []byte) (err error) {
+	return r.ReloadWith(TomlDecoder, data)
+}
+
+// ReloadWith 同 Reload, 使用 decoder 解码配置数据
+func (r *Reloader) ReloadWith(decoder Decoder, data []byte) (err error) {
+	if len(data) == 0 {
+		return ErrContentIsEmpty
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fresh := reflect.New(r.typ).Interface()
+	if err = decode(decoder, data, fresh); err != nil {
+		logger.Errorf("reload, failed to decode content, err:%s", err.Error())
+		return err
+	}
+
+	if err = Validate(fresh); err == nil && r.validator != nil {
+		err = r.validator(fresh)
+	}
+	if err != nil {
+		logger.Errorf("reload, config is invalid and will not be applied, err:%s", err.Error())
+		return err
+	}
+
+	old := r.value.Load()
+	changed := diffKeys(old, fresh)
+	r.value.Store(fresh)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	logger.Infof("reload, config changed, keys:%v", changed)
+	r.cbMu.RLock()
+	callbacks := r.callbacks
+	r.cbMu.RUnlock()
+	for _, fn := range callbacks {
+		r.notify(fn, old, fresh, changed)
+	}
+	return nil
+}
+
+func (r *Reloader) notify(fn ChangeFunc, old, new interface{}, changed ChangedKeys) {
+	defer func() {
+		if e := recover(); e != nil {
+			logger.Errorf("reload, config change callback panic:%v", e)
+		}
+	}()
+	fn(old, new, changed)
+}
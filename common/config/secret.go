@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/utils/cryptos"
+)
+
+// 配置中形如 ENC(...) 的字符串为加密值, 括号内为 cryptos.EncodeAESGCM 加密后的 base64 内容,
+// 配置解码后会使用 KeyProvider 提供的密钥自动解密, 加密值可以通过 Encrypt 或 cmd/config_encrypt 生成
+
+const (
+	secretPrefix = "ENC("
+	secretSuffix = ")"
+
+	// DefaultKeyEnv 默认从该环境变量读取配置解密密钥
+	DefaultKeyEnv = "CONFIG_ENCRYPT_KEY"
+)
+
+var ErrSecretKeyIsEmpty = errors.New("config encrypt key is empty")
+
+// KeyProvider 提供配置加解密所用的 AES 密钥, 密钥长度为 16, 24 或 32 字节
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// KeyProviderFunc 函数形式的 KeyProvider
+type KeyProviderFunc func() ([]byte, error)
+
+func (f KeyProviderFunc) Key() ([]byte, error) {
+	return f()
+}
+
+// EnvKeyProvider 从环境变量读取密钥, Name 为空时使用 DefaultKeyEnv
+type EnvKeyProvider struct {
+	Name string
+}
+
+func (p EnvKeyProvider) Key() ([]byte, error) {
+	name := p.Name
+	if len(name) == 0 {
+		name = DefaultKeyEnv
+	}
+	key := os.Getenv(name)
+	if len(key) == 0 {
+		return nil, ErrSecretKeyIsEmpty
+	}
+	return []byte(key), nil
+}
+
+// FileKeyProvider 从文件读取密钥, 文件首尾的空白字符会被忽略
+type FileKeyProvider struct {
+	Path string
+}
+
+func (p FileKeyProvider) Key() ([]byte, error) {
+	content, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(content)
+	if len(key) == 0 {
+		return nil, ErrSecretKeyIsEmpty
+	}
+	return key, nil
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider = EnvKeyProvider{}
+)
+
+// SetKeyProvider 设置配置解密密钥的来源, 默认为 EnvKeyProvider{}
+func SetKeyProvider(p KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = p
+}
+
+func getKeyProvider() KeyProvider {
+	keyProviderMu.RLock()
+	defer keyProviderMu.RUnlock()
+	return keyProvider
+}
+
+// Encrypt 使用 key 加密配置值, 返回 ENC(...) 形式的字符串, 可直接写入配置文件
+func Encrypt(plaintext, key []byte) (string, error) {
+	content, _, err := cryptos.EncodeAESGCM(plaintext, key)
+	if err != nil {
+		return "", err
+	}
+	return secretPrefix + string(content) + secretSuffix, nil
+}
+
+// Decrypt 使用 key 解密 ENC(...) 形式的配置值, 非加密值原样返回
+func Decrypt(value string, key []byte) (string, error) {
+	if !isSecret(value) {
+		return value, nil
+	}
+	content, err := cryptos.DecodeAESGCM([]byte(value[len(secretPrefix):len(value)-len(secretSuffix)]), key)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func isSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix) && strings.HasSuffix(value, secretSuffix)
+}
+
+// decode 使用 decoder 解码配置到 v, 并解密其中的 ENC(...) 值
+func decode(decoder Decoder, data []byte, v interface{}) (err error) {
+	if err = decoder.Decode(data, v); err != nil {
+		return err
+	}
+	if !bytes.Contains(data, []byte(secretPrefix)) {
+		return nil
+	}
+	return (&secretWalker{}).walk(reflect.ValueOf(v), "")
+}
+
+// secretWalker 遍历配置并解密 ENC(...) 值, 密钥在遇到第一个加密值时才会读取
+type secretWalker struct {
+	key []byte
+}
+
+func (s *secretWalker) decrypt(value, path string) (string, error) {
+	if s.key == nil {
+		key, err := getKeyProvider().Key()
+		if err != nil {
+			return "", fmt.Errorf("failed to get config encrypt key for %s: %w", path, err)
+		}
+		s.key = key
+	}
+
+	plaintext, err := Decrypt(value, s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt config value of %s: %w", path, err)
+	}
+	return plaintext, nil
+}
+
+func (s *secretWalker) walk(v reflect.Value, path string) (err error) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return s.walk(v.Elem(), path)
+		}
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return nil
+		}
+		// interface 中的值不可寻址, 复制后解密再写回
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err = s.walk(elem, path); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if len(t.Field(i).PkgPath) != 0 {
+				continue
+			}
+			if err = s.walk(v.Field(i), joinKey(path, fieldName(t.Field(i)))); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(k))
+			if err = s.walk(elem, joinKey(path, fmt.Sprint(k.Interface()))); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, elem)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err = s.walk(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		if v.CanSet() && isSecret(v.String()) {
+			plaintext, err := s.decrypt(v.String(), path)
+			if err != nil {
+				return err
+			}
+			v.SetString(plaintext)
+		}
+	}
+	return nil
+}
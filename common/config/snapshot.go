@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// DefaultSnapshotDir 配置中心本地快照的默认目录, 从配置中心获取的配置校验通过或热更新成功后保存快照,
+// 配置中心不可用时使用快照中的旧配置启动
+const DefaultSnapshotDir = "tmp/config/snapshot"
+
+// snapshot 记录配置中心是否处于不可用、正在使用本地快照的状态
+type snapshot struct {
+	stale int32
+	// recovery 配置中心恢复前的后台重试, CancelListenConfig 时停止
+	recovery listener
+}
+
+// Stale 是否正在使用本地快照中的旧配置, 配置中心恢复后变为 false
+func (s *snapshot) Stale() bool {
+	return atomic.LoadInt32(&s.stale) == 1
+}
+
+func (s *snapshot) setStale(stale bool) {
+	if stale {
+		atomic.StoreInt32(&s.stale, 1)
+	} else {
+		atomic.StoreInt32(&s.stale, 0)
+	}
+}
+
+// recover 在后台按指数退避重试 fetch 直到配置中心恢复, 恢复后的配置与快照不同时调用 onChange,
+// 配置中心返回配置被删除或为空时不再重试, 调用 stopRecover 后停止
+func (s *snapshot) recover(stale []byte, fetch func() ([]byte, error), onChange func(content []byte)) {
+	_ = s.recovery.start(func(ctx context.Context) {
+		backoff := retryMinBackoff
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			content, err := fetch()
+			switch {
+			case err == nil:
+				s.setStale(false)
+				if !bytes.Equal(content, stale) {
+					onChange(content)
+				}
+				return
+			case err == ErrContentIsEmpty:
+				logger.Warnf("snapshot, config content is deleted or empty, stop retrying and keep running with stale config")
+				return
+			}
+			if backoff *= 2; backoff > retryMaxBackoff {
+				backoff = retryMaxBackoff
+			}
+		}
+	})
+}
+
+// stopRecover 停止 recover 的后台重试, 并等待重试协程退出
+func (s *snapshot) stopRecover() {
+	s.recovery.stop()
+}
+
+// snapshotPath 获取快照文件路径, 禁用快照时返回空
+func snapshotPath(dir string, disable bool, parts ...string) string {
+	if disable {
+		return ""
+	}
+	if len(dir) == 0 {
+		dir = DefaultSnapshotDir
+	}
+	name := strings.Join(parts, "_")
+	name = strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(name)
+	return filepath.Join(dir, name)
+}
+
+// saveSnapshot 保存快照, 先写临时文件再重命名, 避免进程退出时留下不完整的快照
+func saveSnapshot(path string, content []byte) {
+	if len(path) == 0 {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logger.Errorf("snapshot, failed to create dir of snapshot:%s, err:%s", path, err.Error())
+		return
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		logger.Errorf("snapshot, failed to write snapshot:%s, err:%s", path, err.Error())
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logger.Errorf("snapshot, failed to rename snapshot:%s, err:%s", path, err.Error())
+	}
+}
+
+func readSnapshot(path string) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, os.ErrNotExist
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, ErrContentIsEmpty
+	}
+	return content, nil
+}
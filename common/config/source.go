@@ -0,0 +1,144 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Source 配置源, FileConfig, NacosConfig, ConsulConfig, EtcdConfig, HttpConfig 均实现了该接口
+type Source interface {
+	// Load 引导配置数据给 conf
+	Load(conf interface{}) error
+	// WatchConfig 配置监听, 变更的配置交给 r 热加载, 不支持监听的配置源返回 ErrWatchNotSupported
+	WatchConfig(r *Reloader) error
+	// CancelListenConfig 取消配置监听
+	CancelListenConfig() error
+}
+
+const (
+	SourceFile   = "file"
+	SourceNacos  = "nacos"
+	SourceConsul = "consul"
+	SourceEtcd   = "etcd"
+	SourceHttp   = "http"
+)
+
+var (
+	ErrWatchNotSupported = errors.New("config source does not support watch")
+	ErrUnknownSource     = errors.New("unknown config source")
+)
+
+// SourceConfig 按 Type 选择配置源, 服务只需修改配置即可切换配置中心, 如:
+//
+//	[source]
+//	type = "etcd"
+//	[source.etcd]
+//	endpoints = ["127.0.0.1:2379"]
+//	key = "/config/app.toml"
+type SourceConfig struct {
+	Type   string        `json:"type"`
+	File   *FileConfig   `json:"file"`
+	Nacos  *NacosConfig  `json:"nacos"`
+	Consul *ConsulConfig `json:"consul"`
+	Etcd   *EtcdConfig   `json:"etcd"`
+	Http   *HttpConfig   `json:"http"`
+}
+
+// Source 获取 Type 对应的配置源
+func (c *SourceConfig) Source() (Source, error) {
+	var source Source
+	switch strings.ToLower(c.Type) {
+	case SourceFile:
+		if c.File != nil {
+			source = c.File
+		}
+	case SourceNacos:
+		if c.Nacos != nil {
+			source = c.Nacos
+		}
+	case SourceConsul:
+		if c.Consul != nil {
+			source = c.Consul
+		}
+	case SourceEtcd:
+		if c.Etcd != nil {
+			source = c.Etcd
+		}
+	case SourceHttp:
+		if c.Http != nil {
+			source = c.Http
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSource, c.Type)
+	}
+
+	if source == nil {
+		return nil, fmt.Errorf("config source %s is not configured", c.Type)
+	}
+	return source, nil
+}
+
+// Load 同 LoadFileConfig
+func (c *FileConfig) Load(conf interface{}) error {
+	return c.LoadFileConfig(conf)
+}
+
+// WatchConfig 本地文件不支持监听
+func (c *FileConfig) WatchConfig(*Reloader) error {
+	return ErrWatchNotSupported
+}
+
+func (c *FileConfig) CancelListenConfig() error {
+	return nil
+}
+
+// Load 同 LoadNacosConfig
+func (c *NacosConfig) Load(conf interface{}) error {
+	return c.LoadNacosConfig(conf)
+}
+
+// Load 同 LoadConsulConfig
+func (c *ConsulConfig) Load(conf interface{}) error {
+	return c.LoadConsulConfig(conf)
+}
+
+// listener 管理配置监听协程的生命周期
+type listener struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start 启动监听协程, 已在监听时返回 ErrAlreadyListening
+func (l *listener) start(fn func(ctx context.Context)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cancel != nil {
+		return ErrAlreadyListening
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	l.cancel, l.done = cancel, done
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+	return nil
+}
+
+// stop 停止监听协程, 并等待协程退出
+func (l *listener) stop() {
+	l.mu.Lock()
+	cancel, done := l.cancel, l.done
+	l.cancel, l.done = nil, nil
+	l.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/go-playground/validator/v10"
+)
+
+// InvalidField 校验失败的配置字段
+type InvalidField struct {
+	Path  string      // 配置 key 路径, 如 redis.port
+	Rule  string      // 校验规则, 如 max
+	Param string      // 校验规则参数, 如 65535
+	Value interface{} // 字段的值, 可能是解密后的密码等敏感内容, 不会包含在 Error() 中, 输出前需自行脱敏
+}
+
+// ValidateError 配置校验错误, 汇总了所有校验失败的字段
+type ValidateError []InvalidField
+
+// Error 只包含字段路径与校验规则, 不包含字段的值, 避免解密后的配置值随错误写入日志
+func (e ValidateError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, f := range e {
+		rule := f.Rule
+		if len(f.Param) != 0 {
+			rule += "=" + f.Param
+		}
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Path, rule))
+	}
+	return "config is invalid, " + strings.Join(msgs, "; ")
+}
+
+var (
+	validateOnce sync.Once
+	validate     *validator.Validate
+)
+
+func getValidate() *validator.Validate {
+	validateOnce.Do(func() {
+		validate = validator.New()
+		validate.SetTagName("validate")
+		validate.RegisterTagNameFunc(fieldName)
+		_ = validate.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+			if fl.Field().Kind() != reflect.String {
+				return fl.Field().Type() == durationType
+			}
+			_, err := time.ParseDuration(fl.Field().String())
+			return err == nil
+		})
+	})
+	return validate
+}
+
+// Validate 按字段上的 `validate:"..."` tag 校验配置, 支持的规则有 required, min, max, oneof, url, duration 等,
+// 详见 https://github.com/go-playground/validator, 存在校验失败的字段时返回 ValidateError
+//
+// conf 不是结构体(或结构体指针)时不做校验
+func Validate(conf interface{}) error {
+	rv := reflect.ValueOf(conf)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	err := getValidate().Struct(rv.Interface())
+	if err == nil {
+		return nil
+	}
+
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+
+	invalid := make(ValidateError, 0, len(errs))
+	for _, fe := range errs {
+		// Namespace 以结构体类型名开头, 如 AppConf.redis.port
+		path := fe.Namespace()
+		if idx := strings.Index(path, "."); idx >= 0 {
+			path = path[idx+1:]
+		}
+		invalid = append(invalid, InvalidField{
+			Path:  path,
+			Rule:  fe.Tag(),
+			Param: fe.Param(),
+			Value: fe.Value(),
+		})
+	}
+	return invalid
+}
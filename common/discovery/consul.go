@@ -0,0 +1,211 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"github.com/hashicorp/consul/api"
+)
+
+type ConsulRegistry struct {
+	Client *api.Client
+
+	Addr      string `json:"addr"`
+	Namespace string `json:"namespace"`
+	// CheckInterval healthz 检测间隔, 为空时使用 10s
+	CheckInterval string `json:"checkInterval"`
+	// DeregisterAfter healthz 检测持续失败多久后由 consul 注销实例, 为空时使用 1m
+	DeregisterAfter string `json:"deregisterAfter"`
+
+	mu       sync.Mutex
+	watchers map[string]*watcher
+}
+
+// newClient 创建 consul 客户端
+func (r *ConsulRegistry) newClient() (err error) {
+	if r.Client != nil {
+		return nil
+	}
+	if len(r.Addr) == 0 {
+		return ErrAddrIsEmpty
+	}
+
+	r.Client, err = api.NewClient(&api.Config{
+		Address:   r.Addr,
+		Namespace: r.Namespace,
+	})
+	if err != nil {
+		logger.Errorf("failed create consul:%s client, err:%s", r.Addr, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Register 注册实例, 实例元数据中带有 healthz 检测地址时, 同时注册 HTTP 健康检查
+func (r *ConsulRegistry) Register(ins *Instance) (err error) {
+	if err = ins.validate(); err != nil {
+		return err
+	}
+	if err = r.newClient(); err != nil {
+		return err
+	}
+
+	registration := &api.AgentServiceRegistration{
+		ID:      ins.id(),
+		Name:    ins.Service,
+		Address: ins.Addr,
+		Port:    int(ins.Port),
+		Meta:    ins.Metadata,
+		Weights: &api.AgentWeights{Passing: int(ins.weight()), Warning: 1},
+	}
+	if healthz, ok := ins.Metadata[MetaHealthz]; ok {
+		registration.Check = &api.AgentServiceCheck{
+			HTTP:                           healthz,
+			Interval:                       r.CheckInterval,
+			Timeout:                        "3s",
+			DeregisterCriticalServiceAfter: r.DeregisterAfter,
+		}
+		if len(registration.Check.Interval) == 0 {
+			registration.Check.Interval = "10s"
+		}
+		if len(registration.Check.DeregisterCriticalServiceAfter) == 0 {
+			registration.Check.DeregisterCriticalServiceAfter = "1m"
+		}
+	}
+
+	if err = r.Client.Agent().ServiceRegister(registration); err != nil {
+		logger.Errorf("consul, failed to register instance to addr:%s, service:%s, id:%s, err:%s", r.Addr, ins.Service, ins.id(), err.Error())
+		return err
+	}
+	logger.Infof("consul, register instance successful to addr:%s, service:%s, id:%s", r.Addr, ins.Service, ins.id())
+	return nil
+}
+
+// Deregister 注销实例
+func (r *ConsulRegistry) Deregister(ins *Instance) (err error) {
+	if err = r.newClient(); err != nil {
+		return err
+	}
+
+	if err = r.Client.Agent().ServiceDeregister(ins.id()); err != nil {
+		logger.Errorf("consul, failed to deregister instance from addr:%s, service:%s, id:%s, err:%s", r.Addr, ins.Service, ins.id(), err.Error())
+		return err
+	}
+	logger.Infof("consul, deregister instance successful from addr:%s, service:%s, id:%s", r.Addr, ins.Service, ins.id())
+	return nil
+}
+
+// Watch 以阻塞查询的方式监听 service 的健康实例
+func (r *ConsulRegistry) Watch(service string, fn func(instances []*Instance)) (err error) {
+	if err = r.newClient(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watchers[service]; ok {
+		return ErrAlreadyWatching
+	}
+
+	instances, lastIndex, err := r.instances(context.Background(), service, 0)
+	if err != nil {
+		logger.Errorf("consul, failed to get instances from addr:%s, service:%s, err:%s", r.Addr, service, err.Error())
+		return err
+	}
+	fn(instances)
+
+	if r.watchers == nil {
+		r.watchers = make(map[string]*watcher)
+	}
+	r.watchers[service] = newWatcher(func(ctx context.Context) {
+		r.watch(ctx, service, lastIndex, fn)
+	})
+	return nil
+}
+
+// CancelWatch 取消监听 service, 会等待监听协程退出后返回
+func (r *ConsulRegistry) CancelWatch(service string) error {
+	r.mu.Lock()
+	w, ok := r.watchers[service]
+	delete(r.watchers, service)
+	r.mu.Unlock()
+
+	if ok {
+		w.stop()
+	}
+	return nil
+}
+
+// watch 阻塞查询 service 的健康实例, 实例变化时调用 fn, 出错后按指数退避重试, 直到 ctx 取消
+func (r *ConsulRegistry) watch(ctx context.Context, service string, lastIndex uint64, fn func(instances []*Instance)) {
+	backoff := retryMinBackoff
+	for {
+		instances, index, err := r.instances(ctx, service, lastIndex)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Errorf("consul, failed to watch instances from addr:%s, service:%s, retry after %s, err:%s", r.Addr, service, backoff, err.Error())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > retryMaxBackoff {
+				backoff = retryMaxBackoff
+			}
+			continue
+		}
+		backoff = retryMinBackoff
+
+		// index 变小说明 consul 数据被重置, 以本次返回的 index 重新开始阻塞查询
+		if index < lastIndex {
+			lastIndex = 0
+		}
+		if index == lastIndex {
+			continue
+		}
+		lastIndex = index
+
+		logger.Infof("consul, instances changed, addr:%s, service:%s, count:%d", r.Addr, service, len(instances))
+		fn(instances)
+	}
+}
+
+// instances 获取 service 的健康实例及 index, index 至少为 1, 避免阻塞查询立即返回
+func (r *ConsulRegistry) instances(ctx context.Context, service string, waitIndex uint64) ([]*Instance, uint64, error) {
+	q := &api.QueryOptions{WaitIndex: waitIndex, WaitTime: watchWaitTime}
+	entries, meta, err := r.Client.Health().Service(service, "", true, q.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	instances := make([]*Instance, 0, len(entries))
+	for _, entry := range entries {
+		addr := entry.Service.Address
+		if len(addr) == 0 {
+			addr = entry.Node.Address
+		}
+		instances = append(instances, &Instance{
+			Id:       entry.Service.ID,
+			Service:  entry.Service.Service,
+			Addr:     addr,
+			Port:     int64(entry.Service.Port),
+			Weight:   int64(entry.Service.Weights.Passing),
+			Metadata: entry.Service.Meta,
+		})
+	}
+
+	index := meta.LastIndex
+	if index == 0 {
+		index = 1
+	}
+	return instances, index, nil
+}
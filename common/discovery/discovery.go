@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/graceful"
+	"github.com/lethexixin/go-funcs/common/logger"
+	"github.com/lethexixin/go-funcs/library/platforms/loadbalance"
+)
+
+// Instance 服务实例
+type Instance struct {
+	// Id 实例 id, 为空时使用 Service-Addr-Port
+	Id      string `json:"id"`
+	Service string `json:"service"`
+	Addr    string `json:"addr"`
+	Port    int64  `json:"port"`
+	// Weight 权重, 为 0 时使用 1
+	Weight   int64             `json:"weight"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// Registry 注册中心, NacosRegistry, ConsulRegistry 均实现了该接口
+type Registry interface {
+	// Register 注册实例
+	Register(ins *Instance) error
+	// Deregister 注销实例
+	Deregister(ins *Instance) error
+	// Watch 监听 service 的健康实例, 返回前会先获取一次实例并调用 fn, 之后实例变化时再调用 fn
+	Watch(service string, fn func(instances []*Instance)) error
+	// CancelWatch 取消监听 service
+	CancelWatch(service string) error
+}
+
+// MetaHealthz 实例元数据中 graceful healthz 检测地址的 key
+const MetaHealthz = "healthz"
+
+var (
+	ErrAddrIsEmpty      = errors.New("discovery center addr is empty")
+	ErrAlreadyWatching  = errors.New("service is already being watched")
+	ErrInstanceNotValid = errors.New("discovery instance service, addr and port are required")
+)
+
+var (
+	// watchWaitTime consul 阻塞查询的最长等待时间
+	watchWaitTime = time.Minute * 5
+	// retryMinBackoff, retryMaxBackoff 注册中心查询出错后的重试间隔, 每次失败翻倍
+	retryMinBackoff = time.Second
+	retryMaxBackoff = time.Second * 30
+)
+
+func (ins *Instance) id() string {
+	if len(ins.Id) != 0 {
+		return ins.Id
+	}
+	return fmt.Sprintf("%s-%s-%d", ins.Service, ins.Addr, ins.Port)
+}
+
+func (ins *Instance) weight() int64 {
+	if ins.Weight <= 0 {
+		return 1
+	}
+	return ins.Weight
+}
+
+func (ins *Instance) validate() error {
+	if len(ins.Service) == 0 || len(ins.Addr) == 0 || ins.Port <= 0 {
+		return ErrInstanceNotValid
+	}
+	return nil
+}
+
+// Register 将实例注册到 r, 实例元数据中会带上 graceful 的 healthz 检测地址,
+// 程序收到退出信号后(见 graceful.UpShutdown)会在 graceful.PhaseStopAccepting 阶段从 r 注销实例,
+// 因升级(graceful.Upgrade)退出时新进程已使用相同的实例注册, 不会注销
+func Register(r Registry, ins *Instance) (err error) {
+	if ins.Metadata == nil {
+		ins.Metadata = make(map[string]string)
+	}
+	if _, ok := ins.Metadata[MetaHealthz]; !ok {
+		ins.Metadata[MetaHealthz] = graceful.HealthzUrl(ins.Addr)
+	}
+
+	if err = r.Register(ins); err != nil {
+		return err
+	}
+
+	graceful.AddShutdownHook("discovery-deregister-"+ins.id(), graceful.PhaseStopAccepting, 0, func(context.Context) error {
+		if graceful.Upgrading() {
+			logger.Infof("graceful upgrade --- keep instance registered for new process, service:%s, addr:%s, port:%d .", ins.Service, ins.Addr, ins.Port)
+			return nil
+		}
+		logger.Infof("graceful shutdown --- deregister instance, service:%s, addr:%s, port:%d .", ins.Service, ins.Addr, ins.Port)
+		return r.Deregister(ins)
+	})
+	return nil
+}
+
+// Balances 将实例转换为负载均衡数据
+func Balances(instances []*Instance) []*loadbalance.Balance {
+	balances := make([]*loadbalance.Balance, 0, len(instances))
+	for _, ins := range instances {
+		balances = append(balances, loadbalance.NewBalance(ins.Addr, ins.Port, ins.weight()))
+	}
+	return balances
+}
+
+// Balancer 使用 LoadBalancer 在 service 的健康实例中做负载均衡, 实例由 Watch 自动更新
+type Balancer struct {
+	lb loadbalance.LoadBalancer
+
+	mu       sync.Mutex
+	balances []*loadbalance.Balance
+}
+
+func NewBalancer(lb loadbalance.LoadBalancer) *Balancer {
+	return &Balancer{lb: lb}
+}
+
+// Watch 监听 r 中 service 的健康实例, 实例变化时更新负载均衡数据
+func (b *Balancer) Watch(r Registry, service string) error {
+	return r.Watch(service, b.Update)
+}
+
+// Update 使用 instances 替换负载均衡数据
+func (b *Balancer) Update(instances []*Instance) {
+	balances := Balances(instances)
+	b.mu.Lock()
+	b.balances = balances
+	b.mu.Unlock()
+}
+
+// Balances 获取当前的负载均衡数据
+func (b *Balancer) Balances() []*loadbalance.Balance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*loadbalance.Balance{}, b.balances...)
+}
+
+// DoBalance 选择一个实例, 没有可用实例时返回 loadbalance.ErrEmptyBalance
+func (b *Balancer) DoBalance(key string) (*loadbalance.Balance, error) {
+	// 加权轮询等负载均衡会修改 Balance 中的状态, 需要串行调用
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lb.DoBalance(b.balances, key)
+}
+
+// watcher 管理监听协程的生命周期
+type watcher struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newWatcher(fn func(ctx context.Context)) *watcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		fn(ctx)
+	}()
+	return w
+}
+
+// stop 停止监听协程, 并等待协程退出
+func (w *watcher) stop() {
+	w.cancel()
+	<-w.done
+}
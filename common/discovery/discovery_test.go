@@ -0,0 +1,207 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/library/platforms/loadbalance"
+)
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+)
+
+// fakeConsulAgent 模拟 consul 服务注册与健康实例查询的 HTTP 接口, 支持 index 阻塞查询
+type fakeConsulAgent struct {
+	mu       sync.Mutex
+	changed  chan struct{}
+	index    uint64
+	services map[string]*api.AgentServiceRegistration
+}
+
+func newFakeConsulAgent() *fakeConsulAgent {
+	return &fakeConsulAgent{changed: make(chan struct{}), index: 10, services: make(map[string]*api.AgentServiceRegistration)}
+}
+
+func (f *fakeConsulAgent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/service/register"):
+		s := &api.AgentServiceRegistration{}
+		_ = json.NewDecoder(req.Body).Decode(s)
+		f.update(func() { f.services[s.ID] = s })
+	case strings.HasPrefix(req.URL.Path, "/v1/agent/service/deregister/"):
+		f.update(func() { delete(f.services, strings.TrimPrefix(req.URL.Path, "/v1/agent/service/deregister/")) })
+	case strings.HasPrefix(req.URL.Path, "/v1/health/service/"):
+		f.health(w, req, strings.TrimPrefix(req.URL.Path, "/v1/health/service/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsulAgent) update(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsulAgent) health(w http.ResponseWriter, req *http.Request, service string) {
+	f.mu.Lock()
+	index, changed := f.index, f.changed
+	f.mu.Unlock()
+
+	if wait, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64); wait >= index {
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := make([]*api.ServiceEntry, 0)
+	for _, s := range f.services {
+		if s.Name == service {
+			entries = append(entries, &api.ServiceEntry{
+				Node:    &api.Node{Address: "127.0.0.1"},
+				Service: &api.AgentService{ID: s.ID, Service: s.Name, Address: s.Address, Port: s.Port, Meta: s.Meta, Weights: *s.Weights},
+			})
+		}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func TestConsulRegistry(t *testing.T) {
+	watchWaitTime = time.Second
+
+	agent := newFakeConsulAgent()
+	srv := httptest.NewServer(agent)
+	defer srv.Close()
+
+	r := &ConsulRegistry{Addr: srv.URL}
+	ins := &Instance{Service: "demo", Addr: "192.168.1.1", Port: 8080, Weight: 3}
+	if err := Register(r, ins); err != nil {
+		t.Fatal(err)
+	}
+	if len(ins.Metadata[MetaHealthz]) == 0 {
+		t.Fatalf("expect healthz metadata, got:%v", ins.Metadata)
+	}
+
+	b := NewBalancer(new(loadbalance.RoundRobinWeight))
+	if err := b.Watch(r, "demo"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.CancelWatch("demo") }()
+	if err := b.Watch(r, "demo"); err != ErrAlreadyWatching {
+		t.Fatalf("expect ErrAlreadyWatching, got:%v", err)
+	}
+	if balances := b.Balances(); len(balances) != 1 || balances[0].Weight() != 3 {
+		t.Fatalf("unexpected balances:%v", balances)
+	}
+
+	if err := r.Register(&Instance{Service: "demo", Addr: "192.168.1.2", Port: 8080}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && len(b.Balances()) != 2; i++ {
+		time.Sleep(time.Millisecond * 20)
+	}
+	if len(b.Balances()) != 2 {
+		t.Fatalf("unexpected balances:%v", b.Balances())
+	}
+
+	// 按权重 3:1 选择实例
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		balance, err := b.DoBalance("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[balance.Addr()]++
+	}
+	if counts["192.168.1.1"] != 6 || counts["192.168.1.2"] != 2 {
+		t.Fatalf("unexpected balance counts:%v", counts)
+	}
+
+	if err := r.Deregister(ins); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && len(b.Balances()) != 1; i++ {
+		time.Sleep(time.Millisecond * 20)
+	}
+	if balances := b.Balances(); len(balances) != 1 || balances[0].Addr() != "192.168.1.2" {
+		t.Fatalf("unexpected balances:%v", balances)
+	}
+}
+
+// fakeNacosNaming 模拟 nacos 服务发现客户端, 服务没有实例时与 sdk 一样 GetService 返回空的实例列表,
+// SelectInstances 返回错误
+type fakeNacosNaming struct {
+	naming_client.INamingClient
+	mu        sync.Mutex
+	hosts     []model.Instance
+	subscribe func(list []model.Instance, err error)
+}
+
+func (f *fakeNacosNaming) SelectInstances(param vo.SelectInstancesParam) ([]model.Instance, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.hosts) == 0 {
+		return []model.Instance{}, errors.New("instance list is empty!")
+	}
+	return f.hosts, nil
+}
+
+func (f *fakeNacosNaming) GetService(param vo.GetServiceParam) (model.Service, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return model.Service{Name: param.ServiceName, Hosts: f.hosts}, nil
+}
+
+func (f *fakeNacosNaming) Subscribe(param *vo.SubscribeParam) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribe = param.SubscribeCallback
+	return nil
+}
+
+func TestNacosWatchEmptyService(t *testing.T) {
+	client := &fakeNacosNaming{}
+	r := &NacosRegistry{Client: client, Addr: "127.0.0.1:8848"}
+
+	updates := make(chan []*Instance, 10)
+	if err := r.Watch("provider", func(instances []*Instance) { updates <- instances }); err != nil {
+		t.Fatal(err)
+	}
+	if instances := <-updates; len(instances) != 0 {
+		t.Fatalf("unexpected instances:%v", instances)
+	}
+
+	// 提供方启动后通过订阅回调收到实例
+	client.mu.Lock()
+	subscribe := client.subscribe
+	client.mu.Unlock()
+	if subscribe == nil {
+		t.Fatal("expect subscribe for empty service")
+	}
+	subscribe([]model.Instance{{Ip: "10.0.0.1", Port: 8080, Weight: 1, Healthy: true, Enable: true}}, nil)
+	if instances := <-updates; len(instances) != 1 || instances[0].Addr != "10.0.0.1" {
+		t.Fatalf("unexpected instances:%v", instances)
+	}
+}
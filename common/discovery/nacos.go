@@ -0,0 +1,211 @@
+package discovery
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"github.com/nacos-group/nacos-sdk-go/v2/clients"
+	"github.com/nacos-group/nacos-sdk-go/v2/clients/naming_client"
+	"github.com/nacos-group/nacos-sdk-go/v2/common/constant"
+	"github.com/nacos-group/nacos-sdk-go/v2/model"
+	"github.com/nacos-group/nacos-sdk-go/v2/vo"
+)
+
+type NacosRegistry struct {
+	Client naming_client.INamingClient
+
+	Addr      string `json:"addr"`
+	Namespace string `json:"namespace"`
+	Group     string `json:"group"`
+	Cluster   string `json:"cluster"`
+
+	mu         sync.Mutex
+	subscribes map[string]*vo.SubscribeParam
+}
+
+// newClient 创建 nacos 服务发现客户端
+func (r *NacosRegistry) newClient() (err error) {
+	if r.Client != nil {
+		return nil
+	}
+	if len(r.Addr) == 0 {
+		return ErrAddrIsEmpty
+	}
+
+	// nacos 相关参数配置,具体配置可参考 https://github.com/nacos-group/nacos-sdk-go
+
+	ipAddr, hPort, _ := net.SplitHostPort(r.Addr)
+	port, _ := strconv.Atoi(hPort)
+	sc := []constant.ServerConfig{*constant.NewServerConfig(ipAddr, uint64(port), constant.WithContextPath("/nacos"))}
+
+	cc := *constant.NewClientConfig(
+		constant.WithNamespaceId(r.Namespace),
+		constant.WithTimeoutMs(5000),
+		constant.WithNotLoadCacheAtStart(true),
+		constant.WithLogDir("tmp/nacos/log"),
+		constant.WithCacheDir("tmp/nacos/cache"),
+		constant.WithLogLevel("error"),
+	)
+
+	r.Client, err = clients.NewNamingClient(vo.NacosClientParam{ClientConfig: &cc, ServerConfigs: sc})
+	if err != nil {
+		logger.Errorf("failed create nacos:%s naming client, err:%s", r.Addr, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Register 注册临时实例, 实例随 nacos 客户端的心跳存活
+func (r *NacosRegistry) Register(ins *Instance) (err error) {
+	if err = ins.validate(); err != nil {
+		return err
+	}
+	if err = r.newClient(); err != nil {
+		return err
+	}
+
+	if _, err = r.Client.RegisterInstance(vo.RegisterInstanceParam{
+		Ip:          ins.Addr,
+		Port:        uint64(ins.Port),
+		Weight:      float64(ins.weight()),
+		Enable:      true,
+		Healthy:     true,
+		Metadata:    ins.Metadata,
+		ClusterName: r.Cluster,
+		ServiceName: ins.Service,
+		GroupName:   r.Group,
+		Ephemeral:   true,
+	}); err != nil {
+		logger.Errorf("nacos, failed to register instance to addr:%s, namespaceId:%s, service:%s, group:%s, err:%s", r.Addr, r.Namespace, ins.Service, r.Group, err.Error())
+		return err
+	}
+	logger.Infof("nacos, register instance successful to addr:%s, namespaceId:%s, service:%s, group:%s, instance:%s:%d", r.Addr, r.Namespace, ins.Service, r.Group, ins.Addr, ins.Port)
+	return nil
+}
+
+// Deregister 注销实例
+func (r *NacosRegistry) Deregister(ins *Instance) (err error) {
+	if err = r.newClient(); err != nil {
+		return err
+	}
+
+	if _, err = r.Client.DeregisterInstance(vo.DeregisterInstanceParam{
+		Ip:          ins.Addr,
+		Port:        uint64(ins.Port),
+		Cluster:     r.Cluster,
+		ServiceName: ins.Service,
+		GroupName:   r.Group,
+		Ephemeral:   true,
+	}); err != nil {
+		logger.Errorf("nacos, failed to deregister instance from addr:%s, namespaceId:%s, service:%s, group:%s, err:%s", r.Addr, r.Namespace, ins.Service, r.Group, err.Error())
+		return err
+	}
+	logger.Infof("nacos, deregister instance successful from addr:%s, namespaceId:%s, service:%s, group:%s, instance:%s:%d", r.Addr, r.Namespace, ins.Service, r.Group, ins.Addr, ins.Port)
+	return nil
+}
+
+// Watch 订阅 service 的健康实例
+func (r *NacosRegistry) Watch(service string, fn func(instances []*Instance)) (err error) {
+	if err = r.newClient(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.subscribes[service]; ok {
+		return ErrAlreadyWatching
+	}
+
+	// 不使用 SelectInstances, 服务还没有实例时(如消费方先于提供方启动)它只返回一个字符串错误,
+	// GetService 返回空的实例列表, 由 instances 过滤健康的实例
+	info, err := r.Client.GetService(vo.GetServiceParam{
+		Clusters:    r.clusters(),
+		ServiceName: service,
+		GroupName:   r.Group,
+	})
+	if err != nil {
+		logger.Errorf("nacos, failed to get instances from addr:%s, namespaceId:%s, service:%s, group:%s, err:%s", r.Addr, r.Namespace, service, r.Group, err.Error())
+		return err
+	}
+	fn(r.instances(service, info.Hosts))
+
+	param := &vo.SubscribeParam{
+		ServiceName: service,
+		Clusters:    r.clusters(),
+		GroupName:   r.Group,
+		SubscribeCallback: func(list []model.Instance, err error) {
+			if err != nil {
+				logger.Errorf("nacos, failed to watch instances from addr:%s, namespaceId:%s, service:%s, group:%s, err:%s", r.Addr, r.Namespace, service, r.Group, err.Error())
+				return
+			}
+			instances := r.instances(service, list)
+			logger.Infof("nacos, instances changed, addr:%s, namespaceId:%s, service:%s, group:%s, count:%d", r.Addr, r.Namespace, service, r.Group, len(instances))
+			fn(instances)
+		},
+	}
+	if err = r.Client.Subscribe(param); err != nil {
+		logger.Errorf("nacos, failed to subscribe instances from addr:%s, namespaceId:%s, service:%s, group:%s, err:%s", r.Addr, r.Namespace, service, r.Group, err.Error())
+		return err
+	}
+
+	if r.subscribes == nil {
+		r.subscribes = make(map[string]*vo.SubscribeParam)
+	}
+	r.subscribes[service] = param
+	return nil
+}
+
+// CancelWatch 取消订阅 service
+func (r *NacosRegistry) CancelWatch(service string) (err error) {
+	r.mu.Lock()
+	param, ok := r.subscribes[service]
+	delete(r.subscribes, service)
+	r.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if err = r.Client.Unsubscribe(param); err != nil {
+		logger.Errorf("nacos, failed to unsubscribe instances from addr:%s, namespaceId:%s, service:%s, group:%s, err:%s", r.Addr, r.Namespace, service, r.Group, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (r *NacosRegistry) clusters() []string {
+	if len(r.Cluster) == 0 {
+		return nil
+	}
+	return []string{r.Cluster}
+}
+
+// instances 过滤出健康且可用的实例, nacos 的权重为浮点数, 四舍五入后至少为 1
+func (r *NacosRegistry) instances(service string, list []model.Instance) []*Instance {
+	instances := make([]*Instance, 0, len(list))
+	for _, item := range list {
+		if !item.Healthy || !item.Enable || item.Weight <= 0 {
+			continue
+		}
+		weight := int64(math.Round(item.Weight))
+		if weight == 0 {
+			weight = 1
+		}
+		instances = append(instances, &Instance{
+			Id:       item.InstanceId,
+			Service:  service,
+			Addr:     item.Ip,
+			Port:     int64(item.Port),
+			Weight:   weight,
+			Metadata: item.Metadata,
+		})
+	}
+	return instances
+}
@@ -0,0 +1,212 @@
+package graceful
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker 依赖检查, 如 redis ping、数据库 ping、kafka 元数据获取、rabbitmq 连接状态,
+// library/platforms 下的 redis.Redis, gorm_db.GormDB, kafka producer/consumer, rabbitmq producer/consumer 均实现了该接口
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc 函数形式的 Checker
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+const (
+	// DefaultCheckTimeout 单个检查的默认超时时间
+	DefaultCheckTimeout = time.Second * 3
+	// DefaultCheckCacheTTL 检查结果的默认缓存时间, 避免探针频繁请求依赖
+	DefaultCheckCacheTTL = time.Second
+
+	CheckStatusOk   = "ok"
+	CheckStatusFail = "fail"
+)
+
+type checkOptions struct {
+	timeout time.Duration
+	ttl     time.Duration
+}
+
+type CheckOption func(*checkOptions)
+
+// CheckTimeout 检查的超时时间
+func CheckTimeout(timeout time.Duration) CheckOption {
+	return func(o *checkOptions) {
+		o.timeout = timeout
+	}
+}
+
+// CheckCacheTTL 检查结果的缓存时间, 为 0 时每次请求都执行检查
+func CheckCacheTTL(ttl time.Duration) CheckOption {
+	return func(o *checkOptions) {
+		o.ttl = ttl
+	}
+}
+
+// CheckResult 单个检查的结果
+type CheckResult struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// CheckReport /livez, /readyz 返回的 JSON 内容
+type CheckReport struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+type check struct {
+	name    string
+	checker Checker
+	opts    checkOptions
+
+	mu     sync.Mutex
+	last   CheckResult
+	cached bool
+}
+
+func newCheck(name string, checker Checker, options ...CheckOption) *check {
+	opts := checkOptions{timeout: DefaultCheckTimeout, ttl: DefaultCheckCacheTTL}
+	for _, o := range options {
+		o(&opts)
+	}
+	return &check{name: name, checker: checker, opts: opts}
+}
+
+// AddLivenessCheck 注册存活检查, 检查失败时 /livez 返回 503, k8s 会重启容器,
+// 只应注册进程自身无法恢复的检查, 依赖不可用应使用 AddReadinessCheck
+func AddLivenessCheck(name string, checker Checker, options ...CheckOption) {
+	defaultManager.AddLivenessCheck(name, checker, options...)
+}
+
+// AddReadinessCheck 注册就绪检查, 检查失败时 /readyz 与 healthz 返回 503, k8s 不再将流量分配到该程序
+func AddReadinessCheck(name string, checker Checker, options ...CheckOption) {
+	defaultManager.AddReadinessCheck(name, checker, options...)
+}
+
+// AddLivenessCheck 注册存活检查
+func (m *Manager) AddLivenessCheck(name string, checker Checker, options ...CheckOption) {
+	m.checksMu.Lock()
+	defer m.checksMu.Unlock()
+	m.livenessChecks = append(m.livenessChecks, newCheck(name, checker, options...))
+}
+
+// AddReadinessCheck 注册就绪检查
+func (m *Manager) AddReadinessCheck(name string, checker Checker, options ...CheckOption) {
+	m.checksMu.Lock()
+	defer m.checksMu.Unlock()
+	m.readinessChecks = append(m.readinessChecks, newCheck(name, checker, options...))
+}
+
+// run 执行检查, 缓存未过期时直接返回上一次的结果, 同一检查的并发请求会等待同一次执行
+func (c *check) run() CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached && time.Since(c.last.CheckedAt) < c.opts.ttl {
+		return c.last
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- c.checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timeout after %s", c.opts.timeout)
+	}
+
+	c.last = CheckResult{Name: c.name, Status: CheckStatusOk, Duration: time.Since(start).String(), CheckedAt: start}
+	if err != nil {
+		c.last.Status, c.last.Error = CheckStatusFail, err.Error()
+	}
+	c.cached = true
+	return c.last
+}
+
+// runChecks 并行执行检查并汇总结果
+func runChecks(checks []*check) CheckReport {
+	report := CheckReport{Status: CheckStatusOk, Checks: make([]CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = checks[i].run()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, r := range report.Checks {
+		if r.Status != CheckStatusOk {
+			report.Status = CheckStatusFail
+		}
+	}
+	return report
+}
+
+func (m *Manager) liveness() CheckReport {
+	m.checksMu.Lock()
+	checks := append([]*check{}, m.livenessChecks...)
+	m.checksMu.Unlock()
+	return runChecks(checks)
+}
+
+// readiness 执行就绪检查, 程序处于 drain 状态、收到 preStop 请求或退出信号后直接返回失败
+func (m *Manager) readiness() CheckReport {
+	m.checksMu.Lock()
+	checks := append([]*check{}, m.readinessChecks...)
+	m.checksMu.Unlock()
+
+	report := runChecks(checks)
+	if !m.Healthy() {
+		name, msg := "shutdown", ErrShuttingDown.Error()
+		if m.Status().State == StateDraining {
+			name, msg = "drain", "application is draining"
+		}
+		report.Status = CheckStatusFail
+		report.Checks = append([]CheckResult{{Name: name, Status: CheckStatusFail, Error: msg, Duration: "0s", CheckedAt: time.Now()}}, report.Checks...)
+	}
+	return report
+}
+
+func writeReport(w http.ResponseWriter, report CheckReport) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if report.Status != CheckStatusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+func (m *Manager) livezHandler(w http.ResponseWriter, _ *http.Request) {
+	writeReport(w, m.liveness())
+}
+
+func (m *Manager) readyzHandler(w http.ResponseWriter, _ *http.Request) {
+	writeReport(w, m.readiness())
+}
@@ -0,0 +1,63 @@
+package graceful
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadinessChecks(t *testing.T) {
+	m := NewManager()
+	var calls int32
+	dbErr := atomic.Value{}
+	dbErr.Store("")
+	m.AddReadinessCheck("db", CheckerFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		if msg := dbErr.Load().(string); len(msg) != 0 {
+			return errors.New(msg)
+		}
+		return nil
+	}), CheckCacheTTL(time.Millisecond*100))
+	m.AddReadinessCheck("redis", CheckerFunc(func(ctx context.Context) error {
+		return nil
+	}))
+	m.AddLivenessCheck("stuck", CheckerFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), CheckTimeout(time.Millisecond*50), CheckCacheTTL(0))
+
+	get := func(handler http.HandlerFunc) (int, CheckReport) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		var report CheckReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, report
+	}
+
+	if code, report := get(m.readyzHandler); code != http.StatusOK || report.Status != CheckStatusOk || len(report.Checks) != 2 {
+		t.Fatalf("unexpected readyz:%d %+v", code, report)
+	}
+
+	// 缓存期内不重复检查
+	dbErr.Store("connection refused")
+	if code, _ := get(m.readyzHandler); code != http.StatusOK || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expect cached result, code:%d, calls:%d", code, calls)
+	}
+	time.Sleep(time.Millisecond * 120)
+	code, report := get(m.readyzHandler)
+	if code != http.StatusServiceUnavailable || report.Checks[0].Name != "db" || report.Checks[0].Error != "connection refused" {
+		t.Fatalf("unexpected readyz:%d %+v", code, report)
+	}
+
+	code, report = get(m.livezHandler)
+	if code != http.StatusServiceUnavailable || report.Checks[0].Status != CheckStatusFail {
+		t.Fatalf("expect stuck liveness check timeout, got:%d %+v", code, report)
+	}
+}
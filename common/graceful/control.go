@@ -0,0 +1,154 @@
+package graceful
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+const (
+	// StateRunning 正常运行
+	StateRunning = "running"
+	// StateDraining 收到 /drain 请求, 就绪检查失败但继续处理请求, 可以通过 /resume 恢复
+	StateDraining = "draining"
+	// StateStopping 收到 preStop(/stop) 请求, 就绪检查失败, 等待退出信号
+	StateStopping = "stopping"
+	// StateShuttingDown 收到退出信号, 正在执行退出函数
+	StateShuttingDown = "shuttingDown"
+	// StateStopped 退出函数执行完成或超时
+	StateStopped = "stopped"
+)
+
+// stateOrder 状态只能向后推进, 只有 Resume 可以从 StateDraining 回到 StateRunning
+var stateOrder = map[string]int{
+	StateRunning:      0,
+	StateDraining:     1,
+	StateStopping:     2,
+	StateShuttingDown: 3,
+	StateStopped:      4,
+}
+
+var ErrShuttingDown = errors.New("application is shutting down")
+
+// Status /status 返回的 JSON 内容
+type Status struct {
+	State string `json:"state"`
+	// Phase 正在执行的退出阶段, 只在 StateShuttingDown 时有值
+	Phase    string `json:"phase,omitempty"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"inFlight"`
+}
+
+func (m *Manager) setState(state, phase string) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if stateOrder[state] < stateOrder[m.state] {
+		return
+	}
+	m.state, m.phase = state, phase
+}
+
+// Status 当前的运行状态
+func (m *Manager) Status() Status {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return Status{State: m.state, Phase: m.phase, Healthy: m.Healthy(), InFlight: atomic.LoadInt64(&m.inflight)}
+}
+
+// Drain 就绪检查返回失败, 不再接收新流量, 但继续处理请求, 不会退出程序
+func (m *Manager) Drain() {
+	atomic.StoreInt32(&m.draining, 1)
+	m.setState(StateDraining, "")
+}
+
+// Resume 从 Drain 状态恢复, 收到 preStop 请求或开始退出后返回 ErrShuttingDown
+func (m *Manager) Resume() error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	if stateOrder[m.state] > stateOrder[StateDraining] {
+		return ErrShuttingDown
+	}
+	atomic.StoreInt32(&m.draining, 0)
+	m.state, m.phase = StateRunning, ""
+	return nil
+}
+
+// control 控制接口只允许 method 方法的请求, 访问限制见 protect
+func (m *Manager) control(method string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		m.protect(fn)(w, r)
+	}
+}
+
+// protect 只允许 loopback 地址或携带正确 token 的请求访问
+func (m *Manager) protect(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !m.authorized(r) {
+			logger.Warnf("graceful control, reject %s %s from addr:%s", r.Method, r.URL.Path, r.RemoteAddr)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (m *Manager) authorized(r *http.Request) bool {
+	if token := m.opts.controlToken; len(token) != 0 {
+		auth := r.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1 {
+			return true
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (m *Manager) writeStatus(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(m.Status())
+}
+
+func (m *Manager) stopHandler(w http.ResponseWriter, _ *http.Request) {
+	logger.Infof("preStop received")
+	// 程序退出, 将 healthz 置为false, k8s的就绪检测才不会将流量分配到该程序
+	m.unHealthz()
+	m.writeStatus(w, http.StatusOK)
+}
+
+func (m *Manager) drainHandler(w http.ResponseWriter, _ *http.Request) {
+	logger.Infof("drain received")
+	m.Drain()
+	m.writeStatus(w, http.StatusOK)
+}
+
+func (m *Manager) resumeHandler(w http.ResponseWriter, _ *http.Request) {
+	logger.Infof("resume received")
+	if err := m.Resume(); err != nil {
+		m.writeStatus(w, http.StatusConflict)
+		return
+	}
+	m.writeStatus(w, http.StatusOK)
+}
+
+func (m *Manager) statusHandler(w http.ResponseWriter, _ *http.Request) {
+	m.writeStatus(w, http.StatusOK)
+}
@@ -0,0 +1,60 @@
+package graceful
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlEndpoints(t *testing.T) {
+	m := NewManager(ControlToken("secret"))
+	do := func(method, path, remoteAddr, token string) (int, Status) {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = remoteAddr
+		if len(token) != 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, r)
+		var status Status
+		_ = json.Unmarshal(w.Body.Bytes(), &status)
+		return w.Code, status
+	}
+
+	if code, _ := do(http.MethodGet, "/stop", "127.0.0.1:1234", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect GET /stop rejected, got:%d", code)
+	}
+	if code, _ := do(http.MethodPost, "/drain", "10.0.0.1:1234", ""); code != http.StatusForbidden {
+		t.Fatalf("expect remote request without token rejected, got:%d", code)
+	}
+	if code, _ := do(http.MethodPost, "/drain", "10.0.0.1:1234", "wrong"); code != http.StatusForbidden {
+		t.Fatalf("expect remote request with wrong token rejected, got:%d", code)
+	}
+
+	code, status := do(http.MethodPost, "/drain", "10.0.0.1:1234", "secret")
+	if code != http.StatusOK || status.State != StateDraining || status.Healthy {
+		t.Fatalf("unexpected drain:%d %+v", code, status)
+	}
+	if code, _ := do(http.MethodGet, "/readyz", "10.0.0.1:1234", ""); code != http.StatusServiceUnavailable {
+		t.Fatalf("expect readyz 503 when draining, got:%d", code)
+	}
+
+	code, status = do(http.MethodPost, "/resume", "[::1]:1234", "")
+	if code != http.StatusOK || status.State != StateRunning || !status.Healthy {
+		t.Fatalf("unexpected resume:%d %+v", code, status)
+	}
+
+	if code, _ := do(http.MethodPost, "/stop", "127.0.0.1:1234", ""); code != http.StatusOK {
+		t.Fatalf("unexpected stop:%d", code)
+	}
+	if code, _ := do(http.MethodPost, "/resume", "127.0.0.1:1234", ""); code != http.StatusConflict {
+		t.Fatalf("expect resume rejected after preStop, got:%d", code)
+	}
+
+	m.Shutdown()
+	code, status = do(http.MethodGet, "/status", "127.0.0.1:1234", "")
+	if code != http.StatusOK || status.State != StateStopped || status.InFlight != 0 {
+		t.Fatalf("unexpected status:%d %+v", code, status)
+	}
+}
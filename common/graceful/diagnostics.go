@@ -0,0 +1,92 @@
+package graceful
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// DefaultDumpDir 收到 DumpHeapShutdownSignals 时 goroutine 栈与 heap profile 的默认保存目录
+const DefaultDumpDir = "tmp/graceful/dump"
+
+// Debug 在 healthz 检测服务上挂载 /debug/pprof/ 与 /debug/goroutines, 访问限制与控制接口相同
+func Debug(enable bool) Option {
+	return func(o *Options) {
+		o.debug = enable
+	}
+}
+
+// DumpDir 收到 DumpHeapShutdownSignals 时 goroutine 栈与 heap profile 的保存目录, 为空时使用 DefaultDumpDir
+func DumpDir(dir string) Option {
+	return func(o *Options) {
+		o.dumpDir = dir
+	}
+}
+
+// debugRoutes 挂载 pprof 与 goroutine 栈接口
+func (m *Manager) debugRoutes() {
+	m.mux.HandleFunc("/debug/pprof/", m.protect(pprof.Index))
+	m.mux.HandleFunc("/debug/pprof/cmdline", m.protect(pprof.Cmdline))
+	m.mux.HandleFunc("/debug/pprof/profile", m.protect(pprof.Profile))
+	m.mux.HandleFunc("/debug/pprof/symbol", m.protect(pprof.Symbol))
+	m.mux.HandleFunc("/debug/pprof/trace", m.protect(pprof.Trace))
+	m.mux.HandleFunc("/debug/goroutines", m.protect(goroutinesHandler))
+}
+
+// goroutinesHandler 返回全部 goroutine 栈, debug 参数同 pprof, 默认为 2, 即与 panic 时的格式相同
+func goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	debug, err := strconv.Atoi(r.URL.Query().Get("debug"))
+	if err != nil {
+		debug = 2
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_ = rpprof.Lookup("goroutine").WriteTo(w, debug)
+}
+
+// Dump 将 goroutine 栈与 heap profile 写入 DumpDir, 文件名包含 pid 与时间, 返回写入的文件
+func (m *Manager) Dump() ([]string, error) {
+	dir := m.opts.dumpDir
+	if len(dir) == 0 {
+		dir = DefaultDumpDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	suffix := fmt.Sprintf("%d-%s", os.Getpid(), time.Now().Format("20060102T150405.000"))
+	files := make([]string, 0, 2)
+	write := func(name string, fn func(f *os.File) error) error {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err = fn(f); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err = f.Close(); err != nil {
+			return err
+		}
+		files = append(files, path)
+		return nil
+	}
+
+	if err := write("goroutine-"+suffix+".txt", func(f *os.File) error {
+		return rpprof.Lookup("goroutine").WriteTo(f, 2)
+	}); err != nil {
+		return files, err
+	}
+	err := write("heap-"+suffix+".pprof", func(f *os.File) error {
+		// 获取最新的内存统计
+		runtime.GC()
+		return rpprof.Lookup("heap").WriteTo(f, 0)
+	})
+	return files, err
+}
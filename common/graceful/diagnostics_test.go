@@ -0,0 +1,47 @@
+package graceful
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	m := NewManager(Debug(true), DumpDir(dir))
+
+	get := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, r)
+		return w
+	}
+	if w := get("/debug/goroutines", "10.0.0.1:1234"); w.Code != http.StatusForbidden {
+		t.Fatalf("expect remote request rejected, got:%d", w.Code)
+	}
+	if w := get("/debug/goroutines", "127.0.0.1:1234"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "TestDiagnostics") {
+		t.Fatalf("unexpected goroutines:%d", w.Code)
+	}
+	if w := get("/debug/pprof/", "127.0.0.1:1234"); w.Code != http.StatusOK {
+		t.Fatalf("unexpected pprof index:%d", w.Code)
+	}
+
+	files, err := m.Dump()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("unexpected dump:%v, err:%v", files, err)
+	}
+	for _, f := range files {
+		if info, err := os.Stat(f); err != nil || info.Size() == 0 || !strings.HasPrefix(f, dir) {
+			t.Fatalf("unexpected dump file:%s, err:%v", f, err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	NewManager().Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/goroutines", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expect debug routes disabled by default, got:%d", w.Code)
+	}
+}
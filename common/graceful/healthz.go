@@ -8,30 +8,24 @@ import (
 	"github.com/lethexixin/go-funcs/common/logger"
 )
 
-func unHealthz() {
-	healthz = false
-}
-
-func handlerUpListen() {
-	go func() {
-		h := func(w http.ResponseWriter, _ *http.Request) {
-			if !healthz {
-				w.WriteHeader(http.StatusServiceUnavailable)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-		}
-		http.HandleFunc("/"+healthzInfo.router, h)
+// routes 在 m.mux 上注册 healthz 检测服务的路由
+func (m *Manager) routes() {
+	// healthz 与 /readyz 相同, 执行全部就绪检查
+	m.mux.HandleFunc("/livez", m.livezHandler)
+	m.mux.HandleFunc("/readyz", m.readyzHandler)
+	if router := m.opts.healthzInfo.router; router != "livez" && router != "readyz" {
+		m.mux.HandleFunc("/"+router, m.readyzHandler)
+	}
 
-		s := func(w http.ResponseWriter, _ *http.Request) {
-			logger.Infof("preStop received")
-			// 程序退出, 将 healthz 置为false, k8s的就绪检测才不会将流量分配到该程序
-			unHealthz()
-		}
-		http.HandleFunc("/stop", s)
+	// 控制接口, 只允许 loopback 地址或携带 ControlToken 的请求访问
+	m.mux.HandleFunc("/stop", m.control(http.MethodPost, m.stopHandler))
+	m.mux.HandleFunc("/drain", m.control(http.MethodPost, m.drainHandler))
+	m.mux.HandleFunc("/resume", m.control(http.MethodPost, m.resumeHandler))
+	m.mux.HandleFunc("/status", m.control(http.MethodGet, m.statusHandler))
+	// 查看与修改日志级别, 见 logger.LevelHandler
+	m.mux.HandleFunc("/log/level", m.protect(logger.LevelHandler().ServeHTTP))
 
-		if err := http.ListenAndServe(":"+healthzInfo.port, nil); err != nil {
-			logger.Errorf("start healthz check err:%s", err.Error())
-		}
-	}()
+	if m.opts.debug {
+		m.debugRoutes()
+	}
 }
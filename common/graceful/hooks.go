@@ -0,0 +1,192 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// Phase 退出阶段, 收到退出信号后按阶段从小到大依次执行退出函数, 同一阶段内的退出函数并行执行,
+// 除预定义的阶段外也可以使用任意整数作为阶段, 如 PhaseDrain + 1
+type Phase int
+
+const (
+	// PhaseStopAccepting 停止接收流量, 如从注册中心注销实例、停止消费
+	PhaseStopAccepting Phase = iota * 10
+	// PhaseDrain 等待处理中的请求完成, 如关闭 http server, gRPC server
+	PhaseDrain
+	// PhaseFlush 刷新缓冲的数据, 如 kafka producer flush、日志 sync
+	PhaseFlush
+	// PhaseClose 关闭资源, 如数据库连接池、rabbitmq channel
+	PhaseClose
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseStopAccepting:
+		return "stopAccepting"
+	case PhaseDrain:
+		return "drain"
+	case PhaseFlush:
+		return "flush"
+	case PhaseClose:
+		return "close"
+	}
+	return fmt.Sprintf("phase(%d)", int(p))
+}
+
+// DefaultHookTimeout 退出函数的默认超时时间
+const DefaultHookTimeout = time.Second * 5
+
+// Hook 退出函数
+type Hook struct {
+	Name  string
+	Phase Phase
+	// Timeout 超时时间, 为 0 时使用 DefaultHookTimeout, 超时后不再等待该函数, 继续执行后续阶段
+	Timeout time.Duration
+	Fn      func(ctx context.Context) error
+}
+
+// HookResult 退出函数的执行结果
+type HookResult struct {
+	Name     string
+	Phase    Phase
+	Err      error
+	TimedOut bool
+	Duration time.Duration
+}
+
+// AddShutdownHook 注册退出函数, 如:
+//
+//	graceful.AddShutdownHook("kafka-producer", graceful.PhaseFlush, 0, func(ctx context.Context) error {
+//		producer.Flush(5000)
+//		return nil
+//	})
+func AddShutdownHook(name string, phase Phase, timeout time.Duration, fn func(ctx context.Context) error) {
+	defaultManager.AddShutdownHook(name, phase, timeout, fn)
+}
+
+// RegisterHook 注册退出函数
+func RegisterHook(hook Hook) {
+	defaultManager.RegisterHook(hook)
+}
+
+// AddShutdownHook 注册退出函数
+func (m *Manager) AddShutdownHook(name string, phase Phase, timeout time.Duration, fn func(ctx context.Context) error) {
+	m.RegisterHook(Hook{Name: name, Phase: phase, Timeout: timeout, Fn: fn})
+}
+
+// RegisterHook 注册退出函数
+func (m *Manager) RegisterHook(hook Hook) {
+	m.hooksMu.Lock()
+	defer m.hooksMu.Unlock()
+	m.hooks = append(m.hooks, hook)
+}
+
+// runHooks 按阶段执行全部退出函数, 并打印汇总日志, ctx 结束后未开始的阶段不再执行
+func (m *Manager) runHooks(ctx context.Context) []HookResult {
+	m.hooksMu.Lock()
+	all := append([]Hook{}, m.hooks...)
+	m.hooksMu.Unlock()
+
+	// 稳定排序, 同一阶段内保持注册顺序
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Phase < all[j].Phase })
+
+	results := make([]HookResult, 0, len(all))
+	for start := 0; start < len(all); {
+		end := start
+		for end < len(all) && all[end].Phase == all[start].Phase {
+			end++
+		}
+		if ctx.Err() != nil {
+			for _, hook := range all[start:] {
+				results = append(results, HookResult{Name: hook.Name, Phase: hook.Phase, Err: ctx.Err(), TimedOut: true})
+			}
+			break
+		}
+		m.setState(StateShuttingDown, all[start].Phase.String())
+		results = append(results, runPhase(ctx, all[start:end])...)
+		start = end
+	}
+
+	logSummary(results)
+	return results
+}
+
+// runPhase 并行执行同一阶段的退出函数, 等待全部完成或超时
+func runPhase(ctx context.Context, phase []Hook) []HookResult {
+	logger.Infof("graceful shutdown --- run %s hooks, count:%d .", phase[0].Phase, len(phase))
+	results := make([]HookResult, len(phase))
+	var wg sync.WaitGroup
+	for i := range phase {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runHook(ctx, phase[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// runHook 执行退出函数, 超时时间取 hook.Timeout 与 ctx 剩余时间的较小值
+func runHook(ctx context.Context, hook Hook) (result HookResult) {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result = HookResult{Name: hook.Name, Phase: hook.Phase}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- hook.Fn(ctx)
+	}()
+
+	select {
+	case result.Err = <-done:
+		result.TimedOut = errors.Is(result.Err, context.DeadlineExceeded)
+	case <-ctx.Done():
+		result.Err, result.TimedOut = ctx.Err(), true
+	}
+	result.Duration = time.Since(start)
+
+	switch {
+	case result.TimedOut:
+		logger.Warnf("graceful shutdown --- hook %s timeout after %s .", hook.Name, timeout)
+	case result.Err != nil:
+		logger.Errorf("graceful shutdown --- hook %s failed, err:%s", hook.Name, result.Err.Error())
+	}
+	return result
+}
+
+func logSummary(results []HookResult) {
+	var succeeded, failed, timedOut []string
+	for _, r := range results {
+		switch {
+		case r.TimedOut:
+			timedOut = append(timedOut, r.Name)
+		case r.Err != nil:
+			failed = append(failed, fmt.Sprintf("%s(%s)", r.Name, r.Err.Error()))
+		default:
+			succeeded = append(succeeded, fmt.Sprintf("%s(%s)", r.Name, r.Duration.Round(time.Millisecond)))
+		}
+	}
+	logger.Infof("graceful shutdown --- hooks finished, succeeded:[%s], failed:[%s], timeout:[%s]",
+		strings.Join(succeeded, ", "), strings.Join(failed, ", "), strings.Join(timedOut, ", "))
+}
@@ -0,0 +1,62 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunHooks(t *testing.T) {
+	m := NewManager()
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string, sleep time.Duration, err error) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			time.Sleep(sleep)
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return err
+		}
+	}
+
+	m.AddShutdownHook("db", PhaseClose, 0, record("db", 0, nil))
+	m.AddShutdownHook("kafka", PhaseFlush, 0, record("kafka", 0, errors.New("flush failed")))
+	m.AddShutdownHook("http-1", PhaseDrain, 0, record("http-1", time.Millisecond*100, nil))
+	m.AddShutdownHook("http-2", PhaseDrain, 0, record("http-2", time.Millisecond*100, nil))
+	m.AddShutdownHook("deregister", PhaseStopAccepting, 0, record("deregister", 0, nil))
+	m.AddShutdownHook("stuck", PhaseFlush, time.Millisecond*50, func(ctx context.Context) error {
+		select {}
+	})
+
+	start := time.Now()
+	results := m.runHooks(context.Background())
+	if cost := time.Since(start); cost > time.Millisecond*180 {
+		t.Fatalf("hooks in the same phase should run in parallel, cost:%s", cost)
+	}
+
+	if len(order) != 5 || order[0] != "deregister" || order[3] != "kafka" || order[4] != "db" {
+		t.Fatalf("unexpected hook order:%v", order)
+	}
+
+	status := make(map[string]HookResult)
+	for _, r := range results {
+		status[r.Name] = r
+	}
+	if len(status) != 6 {
+		t.Fatalf("unexpected results:%+v", results)
+	}
+	if r := status["stuck"]; !r.TimedOut {
+		t.Fatalf("expect stuck hook timeout, got:%+v", r)
+	}
+	if r := status["kafka"]; r.Err == nil || r.TimedOut {
+		t.Fatalf("expect kafka hook failed, got:%+v", r)
+	}
+	if r := status["db"]; r.Err != nil || r.Phase != PhaseClose {
+		t.Fatalf("expect db hook succeeded, got:%+v", r)
+	}
+}
@@ -0,0 +1,70 @@
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// inFlightPollInterval 等待处理中的请求完成时检查计数的间隔
+const inFlightPollInterval = time.Millisecond * 50
+
+// Track 使用默认管理器记录一个处理中的请求, 请求结束时调用返回的函数
+func Track() (done func()) {
+	return defaultManager.Track()
+}
+
+// InFlight 默认管理器处理中的请求数
+func InFlight() int64 {
+	return defaultManager.InFlight()
+}
+
+// TrackHandler 使用默认管理器统计 next 处理中的请求数
+func TrackHandler(next http.Handler) http.Handler {
+	return defaultManager.TrackHandler(next)
+}
+
+// Track 记录一个处理中的请求, 请求结束时调用返回的函数, 多次调用 done 只会计数一次,
+// 首次调用时注册 PhaseDrain 阶段的退出函数, 等待处理中的请求完成后再执行后续阶段(关闭下游客户端等)
+func (m *Manager) Track() (done func()) {
+	m.inFlightOnce.Do(func() {
+		m.AddShutdownHook("inflight-requests", PhaseDrain, defaultDrainTimeout, m.WaitInFlight)
+	})
+
+	atomic.AddInt64(&m.inflight, 1)
+	var released int32
+	return func() {
+		if atomic.CompareAndSwapInt32(&released, 0, 1) {
+			atomic.AddInt64(&m.inflight, -1)
+		}
+	}
+}
+
+// InFlight 处理中的请求数
+func (m *Manager) InFlight() int64 {
+	return atomic.LoadInt64(&m.inflight)
+}
+
+// WaitInFlight 等待处理中的请求数降为 0, ctx 结束时仍未完成则返回 ctx.Err()
+func (m *Manager) WaitInFlight(ctx context.Context) error {
+	ticker := time.NewTicker(inFlightPollInterval)
+	defer ticker.Stop()
+	for m.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// TrackHandler 统计 next 处理中的请求数, gin 与 gRPC 分别见 mid_gin.InFlight 与 grpc.InFlightUnaryInterceptor
+func (m *Manager) TrackHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done := m.Track()
+		defer done()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackHandler(t *testing.T) {
+	m := NewManager()
+	var closedAt time.Time
+	m.AddShutdownHook("db", PhaseClose, 0, func(ctx context.Context) error {
+		closedAt = time.Now()
+		return nil
+	})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := m.TrackHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	<-started
+
+	if n := m.Status().InFlight; n != 1 {
+		t.Fatalf("expect 1 in-flight request, got:%d", n)
+	}
+
+	var finishedAt time.Time
+	time.AfterFunc(time.Millisecond*100, func() {
+		finishedAt = time.Now()
+		close(release)
+	})
+	if code := m.Shutdown(); code != 0 {
+		t.Fatalf("unexpected exit code:%d", code)
+	}
+	if m.InFlight() != 0 || closedAt.Before(finishedAt) {
+		t.Fatalf("expect downstream closed after in-flight requests, inFlight:%d", m.InFlight())
+	}
+}
@@ -0,0 +1,305 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// Manager 优雅上下线管理器, 包含 healthz 检测服务、退出函数、依赖检查,
+// 包级别的函数(UpShutdown, AddShutdownHook, AddReadinessCheck 等)均作用于 Default 返回的默认管理器
+type Manager struct {
+	opts Options
+
+	mux        *http.ServeMux
+	routesOnce sync.Once
+	healthzSrv *http.Server
+
+	upgrader upgrader
+
+	unhealthy int32
+	draining  int32
+	// upgraded 新进程已就绪, 当前进程因升级退出
+	upgraded int32
+	inflight  int64
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	stateMu sync.Mutex
+	state   string
+	phase   string
+
+	hooksMu      sync.Mutex
+	hooks        []Hook
+	inFlightOnce sync.Once
+
+	checksMu        sync.Mutex
+	livenessChecks  []*check
+	readinessChecks []*check
+
+	upOnce       sync.Once
+	shutdownOnce sync.Once
+	exitCode     int
+}
+
+type Options struct {
+	healthzInfo    HealthzInfo
+	timeout        time.Duration
+	exitCode       int
+	forceExitCode  int
+	mux            *http.ServeMux
+	controlToken   string
+	signals        []os.Signal
+	upgrade        bool
+	upSignals      []os.Signal
+	upgradeTimeout time.Duration
+	debug          bool
+	dumpDir        string
+	exit           func(code int)
+}
+
+type Option func(*Options)
+
+const (
+	DefaultHealthzPort   = "7299"
+	DefaultHealthzRouter = "healthz"
+	// DefaultForceExitCode NewManager 创建的管理器退出超时被强制终止时的退出码, 默认管理器(包级别函数)为 0
+	DefaultForceExitCode = 1
+)
+
+// Healthz healthz 检测服务的端口与路由
+func Healthz(port, router string) Option {
+	return func(o *Options) {
+		o.healthzInfo = HealthzInfo{port: port, router: router}
+	}
+}
+
+// Timeout 退出的最长等待时间, 超时后不再等待未完成的退出函数
+func Timeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.timeout = timeout
+	}
+}
+
+// ExitCode 正常退出时的退出码
+func ExitCode(code int) Option {
+	return func(o *Options) {
+		o.exitCode = code
+	}
+}
+
+// ForceExitCode 退出超时被强制终止时的退出码
+func ForceExitCode(code int) Option {
+	return func(o *Options) {
+		o.forceExitCode = code
+	}
+}
+
+// ServeMux healthz 检测服务使用的 ServeMux, 为 nil 时使用独立创建的 ServeMux
+func ServeMux(mux *http.ServeMux) Option {
+	return func(o *Options) {
+		o.mux = mux
+	}
+}
+
+// ControlToken /stop, /drain, /resume, /status 等控制接口的共享 token, 非 loopback 地址的请求
+// 需携带 Authorization: Bearer <token>, 为空时只允许 loopback 地址访问
+func ControlToken(token string) Option {
+	return func(o *Options) {
+		o.controlToken = token
+	}
+}
+
+// Signals 触发退出的信号, 默认为 ShutdownSignals
+func Signals(signals ...os.Signal) Option {
+	return func(o *Options) {
+		o.signals = signals
+	}
+}
+
+// ExitFunc UpShutdown 退出进程的函数, 默认为 os.Exit, 测试时可替换
+func ExitFunc(exit func(code int)) Option {
+	return func(o *Options) {
+		o.exit = exit
+	}
+}
+
+func NewManager(options ...Option) *Manager {
+	opts := Options{
+		healthzInfo:   HealthzInfo{port: DefaultHealthzPort, router: DefaultHealthzRouter},
+		timeout:       defaultShutDownTime,
+		forceExitCode: DefaultForceExitCode,
+		signals:       ShutdownSignals,
+		upSignals:     UpgradeSignals,
+		exit:          os.Exit,
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	m := &Manager{opts: opts, mux: opts.mux, state: StateRunning}
+	if m.mux == nil {
+		m.mux = http.NewServeMux()
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	return m
+}
+
+// Context 返回的 ctx 在开始退出时被取消, 可用于通知后台任务停止
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Healthy 程序是否健康, 收到 preStop 请求、处于 drain 状态或开始退出后为 false
+func (m *Manager) Healthy() bool {
+	return atomic.LoadInt32(&m.unhealthy) == 0 && atomic.LoadInt32(&m.draining) == 0
+}
+
+func (m *Manager) unHealthz() {
+	atomic.StoreInt32(&m.unhealthy, 1)
+	m.setState(StateStopping, "")
+}
+
+// HealthzUrl 获取 host 上的 healthz 检测地址, 用于注册中心的健康检查
+func (m *Manager) HealthzUrl(host string) string {
+	return fmt.Sprintf("http://%s/%s", net.JoinHostPort(host, m.opts.healthzInfo.port), m.opts.healthzInfo.router)
+}
+
+// Handler 返回 healthz 检测服务的 Handler, 可以挂载到程序自己的 http server 上
+func (m *Manager) Handler() http.Handler {
+	m.routesOnce.Do(m.routes)
+	return m.mux
+}
+
+// Listen 在后台启动 healthz 检测服务, 监听 socket 通过 Listener 创建, 升级时交给新进程继承
+func (m *Manager) Listen() {
+	ln, err := m.Listener("tcp", ":"+m.opts.healthzInfo.port)
+	if err != nil {
+		logger.Errorf("start healthz check err:%s", err.Error())
+		return
+	}
+	m.healthzSrv = &http.Server{Handler: m.Handler()}
+	go func(srv *http.Server) {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("start healthz check err:%s", err.Error())
+		}
+	}(m.healthzSrv)
+}
+
+// Wait 等待退出信号或 ctx 结束, 然后执行 Shutdown 并返回退出码, 不会退出进程,
+// 开启 Upgrade 时收到 UpgradeSignals 会启动新进程, 新进程就绪后执行 Shutdown
+func (m *Manager) Wait(ctx context.Context) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, m.opts.signals...)
+	defer signal.Stop(signals)
+
+	var upgrades chan os.Signal
+	if m.opts.upgrade && len(m.opts.upSignals) != 0 {
+		upgrades = make(chan os.Signal, 1)
+		signal.Notify(upgrades, m.opts.upSignals...)
+		defer signal.Stop(upgrades)
+	}
+
+	// 由 Upgrade 启动的进程通知父进程已就绪
+	m.Ready()
+
+	for {
+		select {
+		case sig := <-signals:
+			logger.Infof("get signal %s, application will shutdown.", sig)
+			// those signals' original behavior is exit with dump ths stack, so we try to keep the behavior
+			for _, dumpSignal := range DumpHeapShutdownSignals {
+				if sig == dumpSignal {
+					m.dump()
+				}
+			}
+			return m.Shutdown()
+		case sig := <-upgrades:
+			logger.Infof("get signal %s, application will upgrade.", sig)
+			if code, ok := m.upgradeShutdown(); ok {
+				return code
+			}
+		case <-ctx.Done():
+			logger.Infof("context done, application will shutdown.")
+			return m.Shutdown()
+		}
+	}
+}
+
+// upgradeShutdown 启动新进程, 新进程就绪后执行 Shutdown, 升级失败时返回 false, 当前进程继续运行
+func (m *Manager) upgradeShutdown() (int, bool) {
+	if err := m.Upgrade(); err != nil {
+		logger.Errorf("graceful upgrade --- failed to upgrade, err:%s", err.Error())
+		return 0, false
+	}
+	atomic.StoreInt32(&m.upgraded, 1)
+	// 新进程已接管监听 socket, healthz 检测由新进程响应
+	if m.healthzSrv != nil {
+		_ = m.healthzSrv.Close()
+	}
+	return m.Shutdown(), true
+}
+
+// Upgrading 当前进程是否因升级而退出, 为 true 时新进程已接管服务,
+// 退出函数中不应执行影响新进程的操作, 如从注册中心注销实例
+func (m *Manager) Upgrading() bool {
+	return atomic.LoadInt32(&m.upgraded) == 1
+}
+
+// Shutdown 执行退出流程并返回退出码: 将 healthz 置为 false, 取消 Context, 按阶段执行退出函数,
+// 超过 Timeout 后不再等待并返回 ForceExitCode, 多次调用只会执行一次
+func (m *Manager) Shutdown() int {
+	m.shutdownOnce.Do(func() {
+		// 程序退出, 将 healthz 置为false, k8s的就绪检测才不会将流量分配到该程序
+		m.unHealthz()
+		m.setState(StateShuttingDown, "")
+		m.cancel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.opts.timeout)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			m.runHooks(ctx)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			m.exitCode = m.opts.exitCode
+		case <-ctx.Done():
+			logger.Warn("shutdown gracefully timeout, application will shutdown immediately. ")
+			m.exitCode = m.opts.forceExitCode
+		}
+		m.setState(StateStopped, "")
+	})
+	return m.exitCode
+}
+
+// UpShutdown 优雅上下线, 启动 healthz 检测服务, 等待退出信号并在退出流程完成后退出进程
+func (m *Manager) UpShutdown() {
+	m.upOnce.Do(func() {
+		m.Listen()
+		m.opts.exit(m.Wait(context.Background()))
+	})
+}
+
+// dump 在执行退出函数前保存 goroutine 栈与 heap profile, 保留的是收到信号时的状态
+func (m *Manager) dump() {
+	files, err := m.Dump()
+	if err != nil {
+		logger.Errorf("graceful shutdown --- dump goroutine and heap failed, err:%s", err.Error())
+	}
+	if len(files) != 0 {
+		logger.Infof("graceful shutdown --- dump goroutine and heap to %v .", files)
+	}
+}
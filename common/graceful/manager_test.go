@@ -0,0 +1,83 @@
+package graceful
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestManagerShutdown(t *testing.T) {
+	m := NewManager(ExitCode(3))
+	var called bool
+	m.AddShutdownHook("flush", PhaseFlush, 0, func(ctx context.Context) error {
+		if m.Healthy() || m.Context().Err() == nil {
+			t.Error("expect unhealthy and context canceled before hooks")
+		}
+		called = true
+		return nil
+	})
+
+	if code := m.Shutdown(); code != 3 || !called {
+		t.Fatalf("unexpected exit code:%d, called:%v", code, called)
+	}
+	// 多次调用只执行一次
+	if code := m.Shutdown(); code != 3 {
+		t.Fatalf("unexpected exit code:%d", code)
+	}
+}
+
+func TestManagerShutdownTimeout(t *testing.T) {
+	m := NewManager(Timeout(time.Millisecond*100), ForceExitCode(2))
+	var closed bool
+	m.AddShutdownHook("stuck", PhaseDrain, time.Second*10, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	m.AddShutdownHook("db", PhaseClose, 0, func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	start := time.Now()
+	if code := m.Shutdown(); code != 2 {
+		t.Fatalf("expect force exit code, got:%d", code)
+	}
+	if cost := time.Since(start); cost > time.Second {
+		t.Fatalf("shutdown should stop waiting after timeout, cost:%s", cost)
+	}
+	if closed {
+		t.Fatal("hooks after timeout should not run")
+	}
+
+	// 默认管理器与之前的 UpShutdown 一样, 超时也以 0 退出
+	if m = NewManager(); m.opts.forceExitCode != DefaultForceExitCode || Default().opts.forceExitCode != 0 {
+		t.Fatalf("unexpected force exit code, manager:%d, default:%d", m.opts.forceExitCode, Default().opts.forceExitCode)
+	}
+}
+
+func TestManagerWait(t *testing.T) {
+	mux := http.NewServeMux()
+	m := NewManager(ServeMux(mux), ExitFunc(func(code int) {
+		t.Fatalf("exit should not be called, code:%d", code)
+	}))
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected healthz:%d", w.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if code := m.Wait(ctx); code != 0 {
+		t.Fatalf("unexpected exit code:%d", code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expect healthz 503 after shutdown, got:%d", w.Code)
+	}
+}
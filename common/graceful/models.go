@@ -1,33 +1,76 @@
 package graceful
 
 import (
+	"context"
 	"net/http"
-	"sync"
 	"time"
 )
 
-const defaultShutDownTime = time.Second * 15
-
-var (
-	once sync.Once
-
-	healthz     = true
-	healthzInfo = HealthzInfo{
-		port:   "7299",
-		router: "healthz",
-	}
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
 
-	servers = make([]HttpSrvInfo, 0)
+const (
+	defaultShutDownTime = time.Second * 15
+	// defaultDrainTimeout 关闭 http server 的超时时间, 为后续阶段的退出函数预留时间
+	defaultDrainTimeout = time.Second * 10
 )
 
+// defaultManager 包级别函数使用的默认管理器
+// defaultManager 包级别函数使用的默认管理器, 与之前的 UpShutdown 保持一致, 退出超时时仍以 0 退出
+var defaultManager = NewManager(ForceExitCode(0))
+
 type HealthzInfo struct {
 	port   string
 	router string
 }
 
+// Default 返回包级别函数使用的默认管理器
+func Default() *Manager {
+	return defaultManager
+}
+
+// SetHealthz 设置默认管理器 healthz 检测服务的端口与路由, 需在 UpShutdown 之前调用
 func SetHealthz(port, router string) {
-	healthzInfo.port = port
-	healthzInfo.router = router
+	defaultManager.opts.healthzInfo = HealthzInfo{port: port, router: router}
+}
+
+// Healthy 程序是否健康, 收到 preStop 请求或退出信号后为 false
+func Healthy() bool {
+	return defaultManager.Healthy()
+}
+
+// Upgrading 默认管理器是否因升级而退出, 见 Manager.Upgrading
+func Upgrading() bool {
+	return defaultManager.Upgrading()
+}
+
+// HealthzUrl 获取 host 上的 healthz 检测地址, 用于注册中心的健康检查
+func HealthzUrl(host string) string {
+	return defaultManager.HealthzUrl(host)
+}
+
+// GrpcServer gRPC server, library/tools/grpc.Server 实现了该接口
+type GrpcServer interface {
+	// Shutdown 将 gRPC 健康检查置为 NOT_SERVING 并优雅关闭, ctx 结束时仍未完成则强制关闭
+	Shutdown(ctx context.Context) error
+}
+
+// SetGrpcSrvInfo 注册 gRPC server, 程序退出时在 PhaseDrain 阶段关闭, 超过 defaultDrainTimeout 后强制关闭
+func SetGrpcSrvInfo(srv GrpcServer, addr string) {
+	defaultManager.SetGrpcSrvInfo(srv, addr)
+}
+
+// SetGrpcSrvInfo 注册 gRPC server, 程序退出时在 PhaseDrain 阶段关闭
+func (m *Manager) SetGrpcSrvInfo(srv GrpcServer, addr string) {
+	m.AddShutdownHook("grpc-server-"+addr, PhaseDrain, defaultDrainTimeout, func(ctx context.Context) error {
+		logger.Infof("graceful shutdown --- destroy grpc server, addr:%s .", addr)
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Errorf("grpc server shutdown err:%s", err.Error())
+			return err
+		}
+		return nil
+	})
 }
 
 type HttpSrvInfo struct {
@@ -35,9 +78,19 @@ type HttpSrvInfo struct {
 	addr string
 }
 
+// SetHttpSrvInfo 注册 http server, 程序退出时在 PhaseDrain 阶段关闭,
+// 需要支持升级时 srv 应使用 Listener 创建的监听 socket
 func SetHttpSrvInfo(srv *http.Server, addr string) {
-	servers = append(servers, HttpSrvInfo{
+	defaultManager.SetHttpSrvInfo(srv, addr)
+}
+
+// SetHttpSrvInfo 注册 http server, 程序退出时在 PhaseDrain 阶段关闭
+func (m *Manager) SetHttpSrvInfo(srv *http.Server, addr string) {
+	info := HttpSrvInfo{
 		Server: srv,
 		addr:   addr,
+	}
+	m.AddShutdownHook("http-server-"+addr, PhaseDrain, defaultDrainTimeout, func(ctx context.Context) error {
+		return destroyRequest(ctx, info)
 	})
 }
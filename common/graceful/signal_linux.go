@@ -18,4 +18,7 @@ var (
 		syscall.SIGQUIT, syscall.SIGILL,
 		syscall.SIGTRAP, syscall.SIGABRT, syscall.SIGSYS,
 	}
+
+	// UpgradeSignals receives signals to fork a new process and hand off listeners, see Manager.Upgrade
+	UpgradeSignals = []os.Signal{syscall.SIGUSR2}
 )
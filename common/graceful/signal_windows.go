@@ -15,4 +15,7 @@ var (
 
 	// DumpHeapShutdownSignals receives shutdown signals to process
 	DumpHeapShutdownSignals = []os.Signal{syscall.SIGQUIT, syscall.SIGILL, syscall.SIGTRAP, syscall.SIGABRT}
+
+	// UpgradeSignals is empty, upgrade is not supported on windows
+	UpgradeSignals = []os.Signal{}
 )
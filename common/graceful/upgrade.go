@@ -0,0 +1,284 @@
+package graceful
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+const (
+	// envListeners 子进程继承的监听 socket, 格式为 network:addr, 以逗号分隔, 依次对应 fd 3, 4, ...
+	envListeners = "GRACEFUL_LISTENERS"
+	// envReadyFd 子进程就绪后写入的管道 fd
+	envReadyFd = "GRACEFUL_READY_FD"
+	// firstInheritedFd 0, 1, 2 为 stdin, stdout, stderr
+	firstInheritedFd = 3
+
+	// DefaultUpgradeTimeout 等待子进程就绪的默认超时时间
+	DefaultUpgradeTimeout = time.Minute
+)
+
+var (
+	ErrUpgradeNotSupported = errors.New("upgrade is not supported on " + runtime.GOOS)
+	ErrUpgradeInProgress   = errors.New("upgrade is in progress")
+)
+
+// upgrader 记录可以交给子进程的监听 socket
+type upgrader struct {
+	mu        sync.Mutex
+	listeners []inheritedListener
+	inherited map[string]net.Listener
+	inherit   sync.Once
+	upgrading bool
+	readyOnce sync.Once
+}
+
+type inheritedListener struct {
+	key string
+	ln  net.Listener
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// Upgrade 收到 UpgradeSignals(SIGUSR2) 时启动新的进程, 通过 Listener 创建的监听 socket 交给新进程继承,
+// 新进程就绪后当前进程执行退出流程, 用于非 k8s 环境下不中断连接的二进制升级
+func Upgrade(enable bool) Option {
+	return func(o *Options) {
+		o.upgrade = enable
+	}
+}
+
+// UpgradeTimeout 等待新进程就绪的超时时间, 超时后杀死新进程并继续运行当前进程
+func UpgradeTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.upgradeTimeout = timeout
+	}
+}
+
+// Listener 使用默认管理器创建监听 socket, 见 Manager.Listener
+func Listener(network, addr string) (net.Listener, error) {
+	return defaultManager.Listener(network, addr)
+}
+
+// Ready 通知父进程默认管理器已就绪, 见 Manager.Ready
+func Ready() {
+	defaultManager.Ready()
+}
+
+// Listener 创建监听 socket, 由 Upgrade 启动的进程会直接使用从父进程继承的 socket,
+// http server 与 gRPC server 需要使用该 socket 才能在升级时不中断连接, 如:
+//
+//	ln, err := graceful.Listener("tcp", ":8080")
+//	go srv.Serve(ln)
+//	graceful.SetHttpSrvInfo(srv, ":8080")
+func (m *Manager) Listener(network, addr string) (net.Listener, error) {
+	m.upgrader.inherit.Do(m.inheritListeners)
+
+	key := network + ":" + addr
+	m.upgrader.mu.Lock()
+	defer m.upgrader.mu.Unlock()
+	ln, ok := m.upgrader.inherited[key]
+	if ok {
+		delete(m.upgrader.inherited, key)
+		logger.Infof("graceful upgrade --- use inherited listener %s .", key)
+	} else {
+		var err error
+		if ln, err = net.Listen(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	m.upgrader.listeners = append(m.upgrader.listeners, inheritedListener{key: key, ln: ln})
+	return ln, nil
+}
+
+// inheritListeners 解析从父进程继承的监听 socket
+func (m *Manager) inheritListeners() {
+	m.upgrader.inherited = make(map[string]net.Listener)
+	value := os.Getenv(envListeners)
+	if len(value) == 0 {
+		return
+	}
+	_ = os.Unsetenv(envListeners)
+
+	for i, key := range strings.Split(value, ",") {
+		f := os.NewFile(uintptr(firstInheritedFd+i), key)
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			logger.Errorf("graceful upgrade --- failed to inherit listener %s, err:%s", key, err.Error())
+			continue
+		}
+		m.upgrader.inherited[key] = ln
+	}
+}
+
+// Ready 通知父进程当前进程已就绪, 父进程收到后开始退出, 不是由 Upgrade 启动的进程调用时不做任何操作,
+// Wait 开始等待信号前会自动调用
+func (m *Manager) Ready() {
+	m.upgrader.readyOnce.Do(func() {
+		value := os.Getenv(envReadyFd)
+		if len(value) == 0 {
+			return
+		}
+		_ = os.Unsetenv(envReadyFd)
+
+		fd, err := strconv.Atoi(value)
+		if err != nil {
+			logger.Errorf("graceful upgrade --- invalid ready fd %s, err:%s", value, err.Error())
+			return
+		}
+		// 未被使用的继承 socket 不再需要
+		m.upgrader.mu.Lock()
+		for key, ln := range m.upgrader.inherited {
+			_ = ln.Close()
+			delete(m.upgrader.inherited, key)
+		}
+		m.upgrader.mu.Unlock()
+
+		f := os.NewFile(uintptr(fd), "ready")
+		defer func() { _ = f.Close() }()
+		if _, err = f.Write([]byte{1}); err != nil {
+			logger.Errorf("graceful upgrade --- failed to notify parent ready, err:%s", err.Error())
+		}
+	})
+}
+
+// Upgrade 启动当前程序的新进程, 将 Listener 创建的监听 socket 交给新进程继承, 等待新进程调用 Ready,
+// 返回 nil 后调用方应执行 Shutdown 退出当前进程, 新进程启动失败或超时未就绪时返回错误, 当前进程继续运行
+func (m *Manager) Upgrade() error {
+	if runtime.GOOS == "windows" {
+		return ErrUpgradeNotSupported
+	}
+
+	m.upgrader.mu.Lock()
+	if m.upgrader.upgrading {
+		m.upgrader.mu.Unlock()
+		return ErrUpgradeInProgress
+	}
+	m.upgrader.upgrading = true
+	listeners := append([]inheritedListener{}, m.upgrader.listeners...)
+	m.upgrader.mu.Unlock()
+	defer func() {
+		m.upgrader.mu.Lock()
+		m.upgrader.upgrading = false
+		m.upgrader.mu.Unlock()
+	}()
+
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	defer func() {
+		for _, f := range files[firstInheritedFd:] {
+			_ = f.Close()
+		}
+	}()
+	keys := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		fl, ok := l.ln.(filer)
+		if !ok {
+			return fmt.Errorf("listener %s does not support file descriptor", l.key)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("failed to get file of listener %s, err:%w", l.key, err)
+		}
+		files = append(files, f)
+		keys = append(keys, l.key)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+	files = append(files, w)
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envListeners+"=") && !strings.HasPrefix(kv, envReadyFd+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, envListeners+"="+strings.Join(keys, ","), envReadyFd+"="+strconv.Itoa(len(files)-1))
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	fds := make([]uintptr, 0, len(files))
+	for _, f := range files {
+		fd, err := rawFd(f)
+		if err != nil {
+			return err
+		}
+		fds = append(fds, fd)
+	}
+	wd, _ := os.Getwd()
+	pid, _, err := syscall.StartProcess(path, os.Args, &syscall.ProcAttr{Dir: wd, Env: env, Files: fds})
+	if err != nil {
+		return fmt.Errorf("failed to start new process, err:%w", err)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	logger.Infof("graceful upgrade --- start new process, pid:%d, listeners:[%s] .", pid, strings.Join(keys, ", "))
+
+	// 关闭父进程持有的写端, 子进程退出时读端才能返回 EOF
+	_ = w.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		if _, err := r.Read(buf); err != nil {
+			ready <- fmt.Errorf("new process exited before ready, err:%w", err)
+			return
+		}
+		ready <- nil
+	}()
+
+	timeout := m.opts.upgradeTimeout
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process not ready after %s", timeout)
+	}
+	if err != nil {
+		_ = process.Kill()
+		_, _ = process.Wait()
+		return err
+	}
+	// 新进程与当前进程互不等待, 当前进程退出后由 init 回收
+	_ = process.Release()
+	logger.Infof("graceful upgrade --- new process %d is ready .", pid)
+	return nil
+}
+
+// rawFd 获取 f 的 fd, 不使用 f.Fd(), Fd() 会将 fd 置为阻塞模式, 与其共享文件描述的监听 socket 也会变为阻塞,
+// 导致当前进程的 Accept 无法被 Close 唤醒
+func rawFd(f *os.File) (uintptr, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var fd uintptr
+	if err = conn.Control(func(v uintptr) { fd = v }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
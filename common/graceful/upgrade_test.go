@@ -0,0 +1,102 @@
+package graceful
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const upgradeTestAddr = "127.0.0.1:0"
+
+// 由 TestUpgrade 启动的子进程, 使用继承的监听 socket 响应请求后退出
+func init() {
+	if len(os.Getenv(envReadyFd)) == 0 {
+		return
+	}
+	m := NewManager()
+	ln, err := m.Listener("tcp", upgradeTestAddr)
+	if err != nil {
+		os.Exit(2)
+	}
+	go func() {
+		_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, "child")
+		}))
+	}()
+	m.Ready()
+	time.Sleep(time.Second)
+	os.Exit(0)
+}
+
+func TestUpgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip(ErrUpgradeNotSupported)
+	}
+
+	m := NewManager(UpgradeTimeout(time.Second * 10))
+	ln, err := m.Listener("tcp", upgradeTestAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "parent")
+	})}
+	go func() { _ = srv.Serve(ln) }()
+
+	if err = m.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	// 父进程关闭后, 同一地址由子进程响应
+	_ = srv.Close()
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "child" {
+		t.Fatalf("expect response from child, got:%s", body)
+	}
+}
+
+func TestUpgradeShutdown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip(ErrUpgradeNotSupported)
+	}
+
+	m := NewManager(UpgradeTimeout(time.Second * 10))
+	ln, err := m.Listener("tcp", upgradeTestAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = ln.Close() }()
+
+	var deregistered, closed bool
+	m.AddShutdownHook("deregister", PhaseStopAccepting, 0, func(ctx context.Context) error {
+		// 升级时新进程已接管服务, 不注销实例
+		if !m.Upgrading() {
+			deregistered = true
+		}
+		return nil
+	})
+	m.AddShutdownHook("close", PhaseClose, 0, func(ctx context.Context) error {
+		closed = true
+		return nil
+	})
+
+	if m.Upgrading() {
+		t.Fatal("expect not upgrading before upgrade")
+	}
+	code, ok := m.upgradeShutdown()
+	if !ok || code != 0 {
+		t.Fatalf("unexpected upgrade result, code:%d, ok:%v", code, ok)
+	}
+	if !m.Upgrading() || deregistered || !closed {
+		t.Fatalf("unexpected hooks, upgrading:%v, deregistered:%v, closed:%v", m.Upgrading(), deregistered, closed)
+	}
+}
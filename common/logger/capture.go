@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"sync"
+)
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Captured Capture 捕获到内存中的日志, 可以使用 observer.ObservedLogs 的 All, FilterMessage, FilterField 等方法查询
+type Captured struct {
+	*observer.ObservedLogs
+
+	prevLog     FieldLogger
+	prevZap     *zap.Logger
+	restoreOnce sync.Once
+}
+
+// Capture 将全局 logger 替换为输出到内存的 logger, 用于测试中断言日志内容, 结束时调用 Restore 恢复之前的 logger, 如:
+//
+//	c := logger.Capture()
+//	t.Cleanup(c.Restore)
+//	...
+//	if !c.Has(zapcore.ErrorLevel, "consume failed", logger.String("topic", "orders")) {
+//		t.Fatal(c.Messages())
+//	}
+//
+// 日志级别仍由 SetLevel, SetNamedLevel 控制, 不采样、不限流、不脱敏;
+// 全局 logger 为进程共享, 捕获期间不要调用 SetLogger, 使用 Capture 的测试不能并行执行
+func Capture() *Captured {
+	core, logs := observer.New(zapcore.DebugLevel)
+	c := &Captured{ObservedLogs: logs, prevLog: log, prevZap: zapLogger}
+	zapLogger = zap.New(wrapLevelCore(&rateLimitCore{Core: core}), zap.AddCaller(), zap.AddCallerSkip(DefaultCallerSkip))
+	log = newZapLog(zapLogger)
+	return c
+}
+
+// Restore 恢复 Capture 之前的 logger, 多次调用只恢复一次
+func (c *Captured) Restore() {
+	c.restoreOnce.Do(func() {
+		log, zapLogger = c.prevLog, c.prevZap
+	})
+}
+
+// Has 是否捕获到级别为 level, 内容为 msg 且包含全部 fields(含 With 添加的字段)的日志
+func (c *Captured) Has(level zapcore.Level, msg string, fields ...Field) bool {
+	logs := c.FilterLevelExact(level).FilterMessage(msg)
+	for _, f := range fields {
+		logs = logs.FilterField(f)
+	}
+	return logs.Len() != 0
+}
+
+// Messages 捕获到的全部日志内容
+func (c *Captured) Messages() []string {
+	entries := c.All()
+	messages := make([]string, 0, len(entries))
+	for _, e := range entries {
+		messages = append(messages, e.Message)
+	}
+	return messages
+}
@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// TraceIdKey 日志中 trace id 的字段名, 也是 gin.Context 中保存 trace id 的 key
+	TraceIdKey = "traceId"
+	// RequestIdKey 日志中 request id 的字段名, 也是 gin.Context 中保存 request id 的 key
+	RequestIdKey = "requestId"
+)
+
+type ctxKey int
+
+const (
+	traceIdCtxKey ctxKey = iota
+	requestIdCtxKey
+)
+
+// ContextWithTraceId 在 ctx 中保存 trace id, WithContext 会将其添加到日志中
+func ContextWithTraceId(ctx context.Context, traceId string) context.Context {
+	return context.WithValue(ctx, traceIdCtxKey, traceId)
+}
+
+// ContextWithRequestId 在 ctx 中保存 request id, WithContext 会将其添加到日志中
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdCtxKey, requestId)
+}
+
+// TraceIdFromContext 获取 ctx 中的 trace id, 兼容 gin.Context 中通过 c.Set(TraceIdKey, id) 保存的值
+func TraceIdFromContext(ctx context.Context) string {
+	return fromContext(ctx, traceIdCtxKey, TraceIdKey)
+}
+
+// RequestIdFromContext 获取 ctx 中的 request id, 兼容 gin.Context 中通过 c.Set(RequestIdKey, id) 保存的值
+func RequestIdFromContext(ctx context.Context) string {
+	return fromContext(ctx, requestIdCtxKey, RequestIdKey)
+}
+
+func fromContext(ctx context.Context, key ctxKey, name string) string {
+	if ctx == nil {
+		return ""
+	}
+	if v, ok := ctx.Value(key).(string); ok {
+		return v
+	}
+	if v, ok := ctx.Value(name).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// contextFields ctx 中的 trace id 与 request id 字段
+func contextFields(ctx context.Context) []Field {
+	fields := make([]Field, 0, 2)
+	if traceId := TraceIdFromContext(ctx); len(traceId) != 0 {
+		fields = append(fields, String(TraceIdKey, traceId))
+	}
+	if requestId := RequestIdFromContext(ctx); len(requestId) != 0 {
+		fields = append(fields, String(RequestIdKey, requestId))
+	}
+	return fields
+}
+
+// NewRequestId 生成 32 位十六进制的随机 request id
+func NewRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ParseTraceParent 从 W3C traceparent 请求头(如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01)中获取 trace id,
+// 格式不正确时返回空字符串
+func ParseTraceParent(traceParent string) string {
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"time"
+)
+
+import (
+	"go.uber.org/zap"
+)
+
+// Field 结构化日志字段, 用于 With 与 Debugw, Infow 等方法
+type Field = zap.Field
+
+func String(key string, val string) Field {
+	return zap.String(key, val)
+}
+
+func Strings(key string, val []string) Field {
+	return zap.Strings(key, val)
+}
+
+func Int(key string, val int) Field {
+	return zap.Int(key, val)
+}
+
+func Int32(key string, val int32) Field {
+	return zap.Int32(key, val)
+}
+
+func Int64(key string, val int64) Field {
+	return zap.Int64(key, val)
+}
+
+func Float64(key string, val float64) Field {
+	return zap.Float64(key, val)
+}
+
+func Bool(key string, val bool) Field {
+	return zap.Bool(key, val)
+}
+
+func Duration(key string, val time.Duration) Field {
+	return zap.Duration(key, val)
+}
+
+func Time(key string, val time.Time) Field {
+	return zap.Time(key, val)
+}
+
+// Err 错误字段, key 为 error, err 为 nil 时不输出
+func Err(err error) Field {
+	return zap.Error(err)
+}
+
+// Any 任意类型的字段, 优先使用上面的类型化字段
+func Any(key string, val interface{}) Field {
+	return zap.Any(key, val)
+}
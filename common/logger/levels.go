@@ -0,0 +1,240 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelRegistry 全局日志级别与按 Named 名称覆盖的日志级别, 运行时可修改
+type levelRegistry struct {
+	global zap.AtomicLevel
+
+	mu sync.Mutex // 串行化 named 的修改
+	// named 为 map[string]zapcore.Level, 修改时整体替换, 读取时无锁
+	named atomic.Value
+}
+
+var levels = newLevelRegistry(DefaultLevel)
+
+func newLevelRegistry(level zapcore.Level) *levelRegistry {
+	l := &levelRegistry{global: zap.NewAtomicLevelAt(level)}
+	l.named.Store(map[string]zapcore.Level{})
+	return l
+}
+
+func (l *levelRegistry) namedLevels() map[string]zapcore.Level {
+	return l.named.Load().(map[string]zapcore.Level)
+}
+
+// enabled name 的日志是否输出 lvl 级别, name 未设置级别时依次查找上级名称(kafka.consumer -> kafka), 都没有时使用全局级别
+func (l *levelRegistry) enabled(name string, lvl zapcore.Level) bool {
+	if named := l.namedLevels(); len(named) != 0 {
+		for n := name; len(n) != 0; {
+			if v, ok := named[n]; ok {
+				return lvl >= v
+			}
+			i := strings.LastIndexByte(n, '.')
+			if i < 0 {
+				break
+			}
+			n = n[:i]
+		}
+	}
+	return l.global.Enabled(lvl)
+}
+
+// anyEnabled 全局或任一名称是否输出 lvl 级别
+func (l *levelRegistry) anyEnabled(lvl zapcore.Level) bool {
+	if l.global.Enabled(lvl) {
+		return true
+	}
+	for _, v := range l.namedLevels() {
+		if lvl >= v {
+			return true
+		}
+	}
+	return false
+}
+
+// setNamed 修改名称的级别, level 为 nil 时删除该名称的级别, replace 为 true 时先清空全部名称的级别
+func (l *levelRegistry) setNamed(levels map[string]*zapcore.Level, replace bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	named := make(map[string]zapcore.Level)
+	if !replace {
+		for k, v := range l.namedLevels() {
+			named[k] = v
+		}
+	}
+	for k, v := range levels {
+		if v == nil {
+			delete(named, k)
+		} else {
+			named[k] = *v
+		}
+	}
+	l.named.Store(named)
+}
+
+// levelCore 按 levelRegistry 过滤日志, 被包装的 core 应输出全部级别
+type levelCore struct {
+	zapcore.Core
+}
+
+func wrapLevelCore(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core}
+}
+
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return levels.anyEnabled(lvl)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields)}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !levels.enabled(ent.LoggerName, ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// ParseLevel 解析日志级别, 如 debug, info, warn, error
+func ParseLevel(text string) (zapcore.Level, error) {
+	var level zapcore.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(text)))
+	return level, err
+}
+
+// AtomicLevel 全局日志级别, 修改后立即生效
+func AtomicLevel() zap.AtomicLevel {
+	return levels.global
+}
+
+// GetLevel 获取全局日志级别
+func GetLevel() zapcore.Level {
+	return levels.global.Level()
+}
+
+// SetLevel 修改全局日志级别, 未通过 SetNamedLevel 设置级别的 logger 均使用该级别
+func SetLevel(level zapcore.Level) {
+	levels.global.SetLevel(level)
+}
+
+// SetNamedLevel 修改 Named 创建的 logger 的级别, 同时作用于其下级名称, 如 kafka 作用于 kafka.consumer
+func SetNamedLevel(name string, level zapcore.Level) {
+	levels.setNamed(map[string]*zapcore.Level{name: &level}, false)
+}
+
+// ResetNamedLevel 删除 name 的级别, 恢复使用上级名称或全局级别
+func ResetNamedLevel(name string) {
+	levels.setNamed(map[string]*zapcore.Level{name: nil}, false)
+}
+
+// SetNamedLevels 使用 named 替换全部名称的级别, 不在 named 中的名称恢复使用全局级别
+func SetNamedLevels(named map[string]zapcore.Level) {
+	m := make(map[string]*zapcore.Level, len(named))
+	for k := range named {
+		v := named[k]
+		m[k] = &v
+	}
+	levels.setNamed(m, true)
+}
+
+// NamedLevels 获取全部名称的级别
+func NamedLevels() map[string]zapcore.Level {
+	named := make(map[string]zapcore.Level)
+	for k, v := range levels.namedLevels() {
+		named[k] = v
+	}
+	return named
+}
+
+// levelPayload LevelHandler 请求与返回的 JSON 内容, named 中级别为空字符串表示删除该名称的级别
+type levelPayload struct {
+	Level string            `json:"level,omitempty"`
+	Named map[string]string `json:"named,omitempty"`
+}
+
+// LevelHandler 查看与修改日志级别的 http 接口:
+//
+//	GET 返回 {"level":"info","named":{"kafka":"debug"}}
+//	PUT/POST {"level":"warn","named":{"kafka":"debug","gorm":""}} 修改全局级别及名称的级别, 级别为空字符串时删除该名称的级别
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			if err := applyLevelPayload(r); err != nil {
+				writeLevel(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			writeLevel(w, http.StatusMethodNotAllowed, map[string]string{"error": "only GET, PUT and POST are supported"})
+			return
+		}
+
+		payload := levelPayload{Level: GetLevel().String(), Named: make(map[string]string)}
+		for k, v := range NamedLevels() {
+			payload.Named[k] = v.String()
+		}
+		writeLevel(w, http.StatusOK, payload)
+	})
+}
+
+func applyLevelPayload(r *http.Request) error {
+	var payload levelPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("invalid body, err:%w", err)
+	}
+
+	var global *zapcore.Level
+	if len(payload.Level) != 0 {
+		level, err := ParseLevel(payload.Level)
+		if err != nil {
+			return err
+		}
+		global = &level
+	}
+	named := make(map[string]*zapcore.Level, len(payload.Named))
+	names := make([]string, 0, len(payload.Named))
+	for name, text := range payload.Named {
+		names = append(names, name)
+		if len(text) == 0 {
+			named[name] = nil
+			continue
+		}
+		level, err := ParseLevel(text)
+		if err != nil {
+			return fmt.Errorf("logger %s, err:%w", name, err)
+		}
+		named[name] = &level
+	}
+
+	// 全部校验通过后再修改
+	if global != nil {
+		SetLevel(*global)
+	}
+	levels.setNamed(named, false)
+	sort.Strings(names)
+	Infof("log level changed, level:%s, named:%v", GetLevel(), names)
+	return nil
+}
+
+func writeLevel(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -1,5 +1,9 @@
 package logger
 
+import (
+	"context"
+)
+
 // Debug is debug level
 func Debug(args ...interface{}) {
 	log.Debug(args...)
@@ -69,3 +73,38 @@ func Panicf(fmt string, args ...interface{}) {
 func Fatalf(fmt string, args ...interface{}) {
 	log.Fatalf(fmt, args...)
 }
+
+// Debugw is structured debug level
+func Debugw(msg string, keysAndValues ...interface{}) {
+	log.Debugw(msg, keysAndValues...)
+}
+
+// Infow is structured info level
+func Infow(msg string, keysAndValues ...interface{}) {
+	log.Infow(msg, keysAndValues...)
+}
+
+// Warnw is structured warning level
+func Warnw(msg string, keysAndValues ...interface{}) {
+	log.Warnw(msg, keysAndValues...)
+}
+
+// Errorw is structured error level
+func Errorw(msg string, keysAndValues ...interface{}) {
+	log.Errorw(msg, keysAndValues...)
+}
+
+// With returns a child logger with fields
+func With(fields ...Field) FieldLogger {
+	return log.With(fields...)
+}
+
+// WithContext returns a child logger with trace id and request id in ctx
+func WithContext(ctx context.Context) FieldLogger {
+	return log.WithContext(ctx)
+}
+
+// Named returns a named child logger
+func Named(name string) FieldLogger {
+	return log.Named(name)
+}
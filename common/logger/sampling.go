@@ -0,0 +1,205 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DropSampled 被采样丢弃
+	DropSampled = "sampled"
+	// DropRateLimited 被限流丢弃
+	DropRateLimited = "rateLimited"
+
+	// RateKeyField 限流 key 的字段名, 见 RateKey
+	RateKeyField = "rateKey"
+
+	DefaultSamplingTickMs = 1000
+	// DefaultRateLimitMaxKeys 限流记录的 key 超过该数量时清空重新计数
+	DefaultRateLimitMaxKeys = 10000
+)
+
+// SamplingConfig 采样配置, 每个 Tick 内相同级别、相同内容的日志先输出 Initial 条, 之后每 Thereafter 条输出 1 条
+type SamplingConfig struct {
+	Initial    int `toml:"initial" yaml:"initial" json:"initial"`
+	Thereafter int `toml:"thereafter" yaml:"thereafter" json:"thereafter"`
+	// TickMs 采样周期, 默认为 DefaultSamplingTickMs
+	TickMs int `toml:"tickMs" yaml:"tickMs" json:"tickMs"`
+}
+
+// RateLimitConfig 限流配置, 每个 key 每秒最多输出 Rate 条日志, 允许突发 Burst 条,
+// key 默认为 logger 名称与日志内容, 可以通过 RateKey 指定
+type RateLimitConfig struct {
+	Rate  float64 `toml:"rate" yaml:"rate" json:"rate"`
+	Burst int     `toml:"burst" yaml:"burst" json:"burst"`
+}
+
+// Sampling 设置采样, 未设置时 release 环境的默认输出(非 Sinks)每秒相同日志先输出 100 条, 之后每 100 条输出 1 条
+func Sampling(conf *SamplingConfig) Option {
+	return func(o *Options) {
+		o.sampling = conf
+	}
+}
+
+// RateLimit 设置按 key 限流
+func RateLimit(conf *RateLimitConfig) Option {
+	return func(o *Options) {
+		o.rateLimit = conf
+	}
+}
+
+// RateKey 指定限流的 key, 通过 With 添加后该 logger 的日志共用一个限流 key, 该字段不会输出, 如:
+//
+//	logger.With(logger.RateKey("kafka.consumer.read")).Errorf("consumer read msg err:%s", err.Error())
+func RateKey(key string) Field {
+	return zap.String(RateKeyField, key)
+}
+
+var (
+	// sampling, limiter 当前 logger 使用的采样与限流, 为 nil 时不采样、不限流
+	sampling *SamplingConfig
+	limiter  *rateLimiter
+
+	// droppedCounts 按原因与级别统计的丢弃条数
+	droppedCounts [2][zapcore.FatalLevel - zapcore.DebugLevel + 1]uint64
+)
+
+// DroppedCount 被采样或限流丢弃的日志条数
+type DroppedCount struct {
+	Reason string
+	Level  zapcore.Level
+	Count  uint64
+}
+
+// Dropped 获取全部原因与级别的丢弃条数, 可以导出到 prometheus
+func Dropped() []DroppedCount {
+	counts := make([]DroppedCount, 0, len(droppedCounts)*len(droppedCounts[0]))
+	for i, reason := range []string{DropSampled, DropRateLimited} {
+		for j := range droppedCounts[i] {
+			counts = append(counts, DroppedCount{
+				Reason: reason,
+				Level:  zapcore.DebugLevel + zapcore.Level(j),
+				Count:  atomic.LoadUint64(&droppedCounts[i][j]),
+			})
+		}
+	}
+	return counts
+}
+
+func addDropped(reason string, lvl zapcore.Level) {
+	if lvl < zapcore.DebugLevel || lvl > zapcore.FatalLevel {
+		return
+	}
+	i := 0
+	if reason == DropRateLimited {
+		i = 1
+	}
+	atomic.AddUint64(&droppedCounts[i][lvl-zapcore.DebugLevel], 1)
+}
+
+// wrapCore 依次按级别、限流、采样过滤日志
+func wrapCore(core zapcore.Core) zapcore.Core {
+	if conf := sampling; conf != nil {
+		tick := time.Duration(conf.TickMs) * time.Millisecond
+		if tick <= 0 {
+			tick = DefaultSamplingTickMs * time.Millisecond
+		}
+		core = zapcore.NewSamplerWithOptions(core, tick, conf.Initial, conf.Thereafter,
+			zapcore.SamplerHook(func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+				if dec&zapcore.LogDropped != 0 {
+					addDropped(DropSampled, ent.Level)
+				}
+			}))
+	}
+	// 未限流时也需要去掉 RateKey 字段
+	return wrapLevelCore(&rateLimitCore{Core: core, limiter: limiter})
+}
+
+// rateLimiter 按 key 的令牌桶
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(conf *RateLimitConfig) *rateLimiter {
+	if conf == nil || conf.Rate <= 0 {
+		return nil
+	}
+	burst := float64(conf.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: conf.Rate, burst: burst, buckets: make(map[string]*rateBucket)}
+}
+
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= DefaultRateLimitMaxKeys {
+			l.buckets = make(map[string]*rateBucket)
+		}
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimitCore 按 key 限流, limiter 为 nil 时不限流, key 为 With 添加的 RateKey, 未添加时为 logger 名称与日志内容
+type rateLimitCore struct {
+	zapcore.Core
+	limiter *rateLimiter
+	key     string
+}
+
+func (c *rateLimitCore) With(fields []zapcore.Field) zapcore.Core {
+	key := c.key
+	kept := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		if f.Key == RateKeyField && f.Type == zapcore.StringType {
+			key = f.String
+			continue
+		}
+		kept = append(kept, f)
+	}
+	return &rateLimitCore{Core: c.Core.With(kept), limiter: c.limiter, key: key}
+}
+
+func (c *rateLimitCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.limiter == nil || !c.Core.Enabled(ent.Level) {
+		return c.Core.Check(ent, ce)
+	}
+	key := c.key
+	if len(key) == 0 {
+		key = ent.LoggerName + "\x00" + ent.Message
+	}
+	if !c.limiter.allow(key, ent.Time) {
+		addDropped(DropRateLimited, ent.Level)
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
@@ -0,0 +1,289 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+import (
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	SinkConsole = "console"
+	SinkFile    = "file"
+	SinkSyslog  = "syslog"
+	SinkHttp    = "http"
+
+	EncoderConsole = "console"
+	EncoderJson    = "json"
+)
+
+var ErrUnknownSink = errors.New("unknown log sink type")
+
+// Config 日志配置, 可以嵌入到应用配置中由 config 包加载, 然后通过 SetLoggerWithConfig 初始化, 如 toml:
+//
+//	[log]
+//	env = "release"
+//	level = "info"
+//	[[log.sinks]]
+//	type = "console"
+//	[[log.sinks]]
+//	type = "file"
+//	file = { filename = "logs/app.log", maxSize = 100, maxBackups = 7 }
+//	[[log.sinks]]
+//	type = "file"
+//	level = "error"
+//	file = { filename = "logs/error.log", maxSize = 100, maxBackups = 7 }
+//	[[log.sinks]]
+//	type = "http"
+//	url = "http://log-collector:8080/batch"
+//	[log.sampling]
+//	initial = 100
+//	thereafter = 100
+//	[log.rateLimit]
+//	rate = 10
+//	burst = 20
+type Config struct {
+	Env   string       `toml:"env" yaml:"env" json:"env"`
+	Level string       `toml:"level" yaml:"level" json:"level"`
+	Sinks []SinkConfig `toml:"sinks" yaml:"sinks" json:"sinks"`
+	// Redact 脱敏配置, 默认 release 环境开启
+	Redact RedactConfig `toml:"redact" yaml:"redact" json:"redact"`
+	// Sampling 采样配置, 见 Sampling
+	Sampling *SamplingConfig `toml:"sampling" yaml:"sampling" json:"sampling"`
+	// RateLimit 限流配置, 见 RateLimit
+	RateLimit *RateLimitConfig `toml:"rateLimit" yaml:"rateLimit" json:"rateLimit"`
+}
+
+// SinkConfig 日志输出目标, 每个目标有独立的编码格式与最低级别
+type SinkConfig struct {
+	// Type 输出类型: console, file, syslog, http
+	Type string `toml:"type" yaml:"type" json:"type"`
+	// Encoder 编码格式: console, json, 为空时 console 类型使用 console, 其余使用 json
+	Encoder string `toml:"encoder" yaml:"encoder" json:"encoder"`
+	// Level 该目标输出的最低级别, 为空时输出全部级别, 全局级别(SetLevel)仍然生效
+	Level string `toml:"level" yaml:"level" json:"level"`
+
+	// Output console 类型输出到 stdout 或 stderr, 默认为 stdout
+	Output string `toml:"output" yaml:"output" json:"output"`
+	// Color console 编码是否输出带颜色的级别
+	Color bool `toml:"color" yaml:"color" json:"color"`
+
+	// File file 类型的滚动日志文件
+	File *FileLogger `toml:"file" yaml:"file" json:"file"`
+
+	// Network syslog 类型的网络协议, udp 或 tcp, 默认为 udp
+	Network string `toml:"network" yaml:"network" json:"network"`
+	// Addr syslog 类型的服务地址, 如 127.0.0.1:514
+	Addr string `toml:"addr" yaml:"addr" json:"addr"`
+	// Tag syslog 的 APP-NAME, 默认为程序名
+	Tag string `toml:"tag" yaml:"tag" json:"tag"`
+	// Facility syslog 的 facility, 默认为 1(user)
+	Facility int `toml:"facility" yaml:"facility" json:"facility"`
+
+	// Url http 类型批量提交日志的地址, 请求体为换行分隔的 JSON
+	Url     string            `toml:"url" yaml:"url" json:"url"`
+	Headers map[string]string `toml:"headers" yaml:"headers" json:"headers"`
+	// BatchSize http 类型每批提交的日志条数, 默认为 DefaultHttpBatchSize
+	BatchSize int `toml:"batchSize" yaml:"batchSize" json:"batchSize"`
+	// FlushIntervalMs http 类型提交的最长间隔, 默认为 DefaultHttpFlushIntervalMs
+	FlushIntervalMs int `toml:"flushIntervalMs" yaml:"flushIntervalMs" json:"flushIntervalMs"`
+	// TimeoutMs http 类型请求的超时时间, 默认为 DefaultHttpTimeoutMs
+	TimeoutMs int `toml:"timeoutMs" yaml:"timeoutMs" json:"timeoutMs"`
+}
+
+// Sinks 同时输出到多个目标, 与 FileLog 同时使用时 FileLog 作为一个 json 编码的 file 目标
+func Sinks(sinks ...SinkConfig) Option {
+	return func(o *Options) {
+		o.sinks = append(o.sinks, sinks...)
+	}
+}
+
+// SetLoggerWithConfig 使用 conf 初始化 logger, options 在 conf 之后应用
+func SetLoggerWithConfig(conf *Config, options ...Option) error {
+	opts := make([]Option, 0, len(options)+6)
+	if len(conf.Env) != 0 {
+		opts = append(opts, Env(EnvLogger(conf.Env)))
+	}
+	if len(conf.Level) != 0 {
+		level, err := ParseLevel(conf.Level)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, Level(level))
+	}
+	if len(conf.Sinks) != 0 {
+		opts = append(opts, Sinks(conf.Sinks...))
+	}
+	opts = append(opts, Redact(conf.Redact))
+	if conf.Sampling != nil {
+		opts = append(opts, Sampling(conf.Sampling))
+	}
+	if conf.RateLimit != nil {
+		opts = append(opts, RateLimit(conf.RateLimit))
+	}
+	return SetLogger(append(opts, options...)...)
+}
+
+// newTeeCore 创建输出到全部 sinks 的 core, r 不为 nil 时脱敏后输出, 返回的 closer 在 logger 被替换后关闭
+func newTeeCore(sinks []SinkConfig, r *Redactor) (zapcore.Core, io.Closer, error) {
+	guard := &sinkGuard{closers: make([]io.Closer, 0, len(sinks))}
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for i, sink := range sinks {
+		core, closer, err := newSinkCore(sink, r)
+		if err != nil {
+			closeAll(guard.closers)
+			return nil, nil, fmt.Errorf("log sink[%d] %s, err:%w", i, sink.Type, err)
+		}
+		cores = append(cores, &guardedCore{Core: core, guard: guard})
+		if closer != nil {
+			guard.closers = append(guard.closers, closer)
+		}
+	}
+	return zapcore.NewTee(cores...), guard, nil
+}
+
+// sinkGuard logger 被替换后, 通过 With, Named 派生的旧 logger 可能仍在写入,
+// 关闭 sink 前等待正在进行的写入完成, 关闭后的写入直接丢弃, 避免写入已关闭的文件、连接
+type sinkGuard struct {
+	mu      sync.RWMutex
+	closed  bool
+	closers []io.Closer
+}
+
+func (g *sinkGuard) Close() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	g.closed = true
+	g.mu.Unlock()
+
+	closeAll(g.closers)
+	return nil
+}
+
+// guardedCore 写入前检查 sink 是否已关闭
+type guardedCore struct {
+	zapcore.Core
+	guard *sinkGuard
+}
+
+func (c *guardedCore) With(fields []zapcore.Field) zapcore.Core {
+	return &guardedCore{Core: c.Core.With(fields), guard: c.guard}
+}
+
+func (c *guardedCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *guardedCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.guard.mu.RLock()
+	defer c.guard.mu.RUnlock()
+	if c.guard.closed {
+		return nil
+	}
+	return c.Core.Write(ent, fields)
+}
+
+func (c *guardedCore) Sync() error {
+	c.guard.mu.RLock()
+	defer c.guard.mu.RUnlock()
+	if c.guard.closed {
+		return nil
+	}
+	return c.Core.Sync()
+}
+
+func newSinkCore(sink SinkConfig, r *Redactor) (zapcore.Core, io.Closer, error) {
+	typ := strings.ToLower(sink.Type)
+	level := zapcore.DebugLevel
+	if len(sink.Level) != 0 {
+		var err error
+		if level, err = ParseLevel(sink.Level); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	encoding := sink.Encoder
+	if len(encoding) == 0 {
+		encoding = EncoderJson
+		if typ == SinkConsole {
+			encoding = EncoderConsole
+		}
+	}
+	encoder, err := newEncoder(encoding, sink.Color)
+	if err != nil {
+		return nil, nil, err
+	}
+	encoder = newRedactEncoder(encoder, r)
+
+	switch typ {
+	case SinkConsole:
+		out := zapcore.Lock(os.Stdout)
+		if sink.Output == "stderr" {
+			out = zapcore.Lock(os.Stderr)
+		}
+		return zapcore.NewCore(encoder, out, level), nil, nil
+	case SinkFile:
+		if sink.File == nil || len(sink.File.Filename) == 0 {
+			return nil, nil, errors.New("file.filename is empty")
+		}
+		w := newFileWriter(sink.File)
+		return zapcore.NewCore(encoder, zapcore.AddSync(w), level), w, nil
+	case SinkSyslog:
+		core, err := newSyslogCore(sink, encoder, level)
+		if err != nil {
+			return nil, nil, err
+		}
+		return core, core, nil
+	case SinkHttp:
+		w, err := newHttpWriter(sink)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zapcore.NewCore(encoder, w, level), w, nil
+	}
+	return nil, nil, ErrUnknownSink
+}
+
+func newEncoder(encoding string, color bool) (zapcore.Encoder, error) {
+	switch encoding {
+	case EncoderConsole:
+		conf := zapLoggerEncoderConfig
+		if color {
+			conf.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+		return zapcore.NewConsoleEncoder(conf), nil
+	case EncoderJson:
+		return zapcore.NewJSONEncoder(zapLoggerEncoderConfig), nil
+	}
+	return nil, fmt.Errorf("unknown log encoder %s", encoding)
+}
+
+func newFileWriter(f *FileLogger) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   f.Filename,
+		MaxSize:    f.MaxSize,
+		MaxAge:     f.MaxAge,
+		MaxBackups: f.MaxBackups,
+		LocalTime:  f.LocalTime,
+		Compress:   f.Compress,
+	}
+}
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHttpBatchSize       = 100
+	DefaultHttpFlushIntervalMs = 1000
+	DefaultHttpTimeoutMs       = 5000
+
+	// httpMaxPending 未提交的日志超过 BatchSize 的倍数时丢弃新日志, 避免日志服务不可用时内存无限增长
+	httpMaxPending = 10
+)
+
+// httpWriter 批量提交日志的 zapcore.WriteSyncer, 每条日志为一行 JSON, 达到 BatchSize 或 FlushIntervalMs 时在后台提交
+type httpWriter struct {
+	client    *http.Client
+	url       string
+	headers   map[string]string
+	batchSize int
+	interval  time.Duration
+
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	count   int
+	dropped int
+
+	flushMu sync.Mutex // 串行化提交
+	notify  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newHttpWriter(sink SinkConfig) (*httpWriter, error) {
+	if len(sink.Url) == 0 {
+		return nil, errors.New("http url is empty")
+	}
+	w := &httpWriter{
+		url:       sink.Url,
+		headers:   sink.Headers,
+		batchSize: sink.BatchSize,
+		interval:  time.Duration(sink.FlushIntervalMs) * time.Millisecond,
+		notify:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if w.batchSize <= 0 {
+		w.batchSize = DefaultHttpBatchSize
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultHttpFlushIntervalMs * time.Millisecond
+	}
+	timeout := time.Duration(sink.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = DefaultHttpTimeoutMs * time.Millisecond
+	}
+	w.client = &http.Client{Timeout: timeout}
+
+	go w.loop()
+	return w, nil
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	if w.count >= w.batchSize*httpMaxPending {
+		w.dropped++
+		w.mu.Unlock()
+		return len(p), nil
+	}
+	w.buf.Write(p)
+	w.count++
+	full := w.count >= w.batchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Sync 同步提交未提交的日志
+func (w *httpWriter) Sync() error {
+	return w.flush()
+}
+
+// Close 提交未提交的日志并停止后台提交
+func (w *httpWriter) Close() error {
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+	return w.flush()
+}
+
+func (w *httpWriter) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.notify:
+		}
+		_ = w.flush()
+	}
+}
+
+func (w *httpWriter) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	w.mu.Lock()
+	if w.count == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	body := make([]byte, w.buf.Len())
+	copy(body, w.buf.Bytes())
+	count, dropped := w.count, w.dropped
+	w.buf.Reset()
+	w.count, w.dropped = 0, 0
+	w.mu.Unlock()
+
+	err := w.post(body)
+	if err != nil || dropped != 0 {
+		// 不能使用 logger 输出, 否则会再次写入该 sink
+		_, _ = fmt.Fprintf(os.Stderr, "logger http sink, post %d entries to url:%s, dropped:%d, err:%v\n", count, w.url, dropped, err)
+	}
+	return err
+}
+
+func (w *httpWriter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// syslogFacilityUser syslog 默认的 facility
+const syslogFacilityUser = 1
+
+// syslogCore 以 RFC 5424 格式通过 udp 或 tcp 发送日志到 syslog 服务, 不依赖 log/syslog, windows 下也可使用
+type syslogCore struct {
+	zapcore.LevelEnabler
+	enc      zapcore.Encoder
+	conn     *syslogConn
+	facility int
+	tag      string
+	hostname string
+}
+
+// syslogConn 多个 syslogCore(With 创建)共享的连接, 写入失败时重连一次
+type syslogConn struct {
+	mu      sync.Mutex
+	network string
+	addr    string
+	conn    net.Conn
+}
+
+func newSyslogCore(sink SinkConfig, enc zapcore.Encoder, level zapcore.LevelEnabler) (*syslogCore, error) {
+	if len(sink.Addr) == 0 {
+		return nil, errors.New("syslog addr is empty")
+	}
+	network := sink.Network
+	if len(network) == 0 {
+		network = "udp"
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("unsupported syslog network %s", network)
+	}
+	tag := sink.Tag
+	if len(tag) == 0 {
+		tag = filepath.Base(os.Args[0])
+	}
+	facility := sink.Facility
+	if facility <= 0 {
+		facility = syslogFacilityUser
+	}
+	hostname, _ := os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "-"
+	}
+
+	c := &syslogConn{network: network, addr: sink.Addr}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return &syslogCore{LevelEnabler: level, enc: enc, conn: c, facility: facility, tag: tag, hostname: hostname}, nil
+}
+
+func (c *syslogCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *syslogCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *syslogCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		c.facility*8+syslogSeverity(ent.Level), ent.Time.Format(time.RFC3339Nano), c.hostname, c.tag, os.Getpid(), buf.Bytes())
+	return c.conn.write(msg)
+}
+
+func (c *syslogCore) Sync() error {
+	return nil
+}
+
+func (c *syslogCore) Close() error {
+	return c.conn.close()
+}
+
+func syslogSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel, zapcore.PanicLevel:
+		return 2
+	}
+	return 1
+}
+
+func (c *syslogConn) dial() error {
+	conn, err := net.DialTimeout(c.network, c.addr, time.Second*5)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+func (c *syslogConn) write(msg string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// 编码后的日志以换行结尾, udp 每条日志一个数据包, tcp 以换行分隔(RFC 6587)
+	if c.network == "udp" && len(msg) != 0 && msg[len(msg)-1] == '\n' {
+		msg = msg[:len(msg)-1]
+	}
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if c.conn == nil {
+			if err = c.dial(); err != nil {
+				continue
+			}
+		}
+		if _, err = c.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+func (c *syslogConn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
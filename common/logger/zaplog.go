@@ -1,15 +1,18 @@
 package logger
 
 import (
+	"context"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 )
 
 import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // this zap logger refer to dubbo-go logger.go
@@ -32,15 +35,67 @@ type Logger interface {
 	Fatalf(fmt string, args ...interface{})
 }
 
+// FieldLogger 支持结构化日志与子 logger 的 Logger, 与 Logger 分开定义, 已有的 Logger 实现不受影响,
+// With, Named 等包级别函数返回的均为 FieldLogger
+type FieldLogger interface {
+	Logger
+
+	// Debugw 等方法输出结构化日志, keysAndValues 可以是 Field, 也可以是交替的 key, value
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+
+	// With 返回添加了 fields 的子 logger
+	With(fields ...Field) FieldLogger
+	// WithContext 返回添加了 ctx 中 trace id, request id 的子 logger
+	WithContext(ctx context.Context) FieldLogger
+	// Named 返回名称为 name 的子 logger, 多次调用时名称以 . 连接
+	Named(name string) FieldLogger
+}
+
+// zapLog 基于 zap.SugaredLogger 的 FieldLogger
+type zapLog struct {
+	*zap.SugaredLogger
+	// child 为 false 时是包级别函数使用的 logger, 调用栈多一层, 创建子 logger 时需要去掉 CallerSkip 中的这一层
+	child bool
+}
+
+func newZapLog(l *zap.Logger) *zapLog {
+	return &zapLog{SugaredLogger: l.Sugar()}
+}
+
+func (z *zapLog) base() *zap.Logger {
+	if z.child {
+		return z.Desugar()
+	}
+	return z.Desugar().WithOptions(zap.AddCallerSkip(-1))
+}
+
+func (z *zapLog) With(fields ...Field) FieldLogger {
+	return &zapLog{SugaredLogger: z.base().With(fields...).Sugar(), child: true}
+}
+
+func (z *zapLog) WithContext(ctx context.Context) FieldLogger {
+	return z.With(contextFields(ctx)...)
+}
+
+func (z *zapLog) Named(name string) FieldLogger {
+	return &zapLog{SugaredLogger: z.base().Named(name).Sugar(), child: true}
+}
+
 type EnvLogger string
 
+// FileLogger 滚动日志文件, 见 lumberjack.Logger
 type FileLogger struct {
-	Filename   string
-	MaxSize    int
-	MaxAge     int
-	MaxBackups int
-	LocalTime  bool
-	Compress   bool
+	Filename string `toml:"filename" yaml:"filename" json:"filename"`
+	// MaxSize 单个文件的最大大小, 单位 MB
+	MaxSize int `toml:"maxSize" yaml:"maxSize" json:"maxSize"`
+	// MaxAge 文件保留的最大天数
+	MaxAge     int  `toml:"maxAge" yaml:"maxAge" json:"maxAge"`
+	MaxBackups int  `toml:"maxBackups" yaml:"maxBackups" json:"maxBackups"`
+	LocalTime  bool `toml:"localTime" yaml:"localTime" json:"localTime"`
+	Compress   bool `toml:"compress" yaml:"compress" json:"compress"`
 }
 
 const (
@@ -49,8 +104,12 @@ const (
 )
 
 var (
-	log       Logger
+	log       FieldLogger
 	zapLogger *zap.Logger
+	// sinkCloser 当前 logger 的 sink 需要关闭的文件、连接等, 为 nil 时不需要关闭
+	sinkCloser io.Closer
+	// redactor 当前 logger 使用的脱敏器, 为 nil 时不脱敏
+	redactor *Redactor
 
 	zapLoggerConfig        = zap.NewDevelopmentConfig()
 	zapLoggerEncoderConfig = zapcore.EncoderConfig{
@@ -67,16 +126,47 @@ var (
 	}
 )
 
+// buildConfig 使用 zapLoggerConfig 创建 logger, 日志级别由 levels 控制, 运行时可通过 SetLevel, SetNamedLevel 修改,
+// 采样由 wrapCore 处理以便统计丢弃的日志
+func buildConfig(options ...zap.Option) (*zap.Logger, error) {
+	zapLoggerConfig.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	zapLoggerConfig.Sampling = nil
+	zapLoggerConfig.Encoding = strings.TrimPrefix(zapLoggerConfig.Encoding, redactEncoderName(""))
+	if redactor != nil {
+		zapLoggerConfig.Encoding = redactEncoderName(zapLoggerConfig.Encoding)
+	}
+	return zapLoggerConfig.Build(append(options, zap.WrapCore(wrapCore))...)
+}
+
+// NoSignalHandlerEnv 设置该环境变量(如 LOGGER_NO_SIGNAL_HANDLER=1)时 init 不注册退出信号的处理
+const NoSignalHandlerEnv = "LOGGER_NO_SIGNAL_HANDLER"
+
+var (
+	signalMu   sync.Mutex
+	exitSignal chan os.Signal
+)
+
 func init() {
 	zapLoggerConfig.EncoderConfig = zapLoggerEncoderConfig
-	zapLogger, _ = zapLoggerConfig.Build()
-	log = zapLogger.Sugar()
+	zapLogger, _ = buildConfig()
+	log = newZapLog(zapLogger)
+
+	if len(os.Getenv(NoSignalHandlerEnv)) == 0 {
+		startSignalHandler()
+	}
+}
+
+func startSignalHandler() {
+	signalMu.Lock()
+	defer signalMu.Unlock()
 
 	// flushes buffer when redirect log to file.
-	var exitSignal = make(chan os.Signal)
+	exitSignal = make(chan os.Signal, 1)
 	signal.Notify(exitSignal, syscall.SIGTERM, syscall.SIGINT)
-	go func() {
-		<-exitSignal
+	go func(ch chan os.Signal) {
+		if _, ok := <-ch; !ok {
+			return
+		}
 		// Sync calls the underlying Core's Sync method, flushing any buffered log entries.
 		// Applications should take care to call Sync before exiting.
 		err := zapLogger.Sync() // flushes buffer, if any
@@ -84,7 +174,25 @@ func init() {
 			log.Infof("zapLogger sync err: %s", err.Error())
 		}
 		os.Exit(0)
-	}()
+	}(exitSignal)
+}
+
+// StopSignalHandler 停止 init 时注册的退出信号处理(收到 SIGTERM, SIGINT 时 Sync 后 os.Exit(0)),
+// 由应用自己处理退出信号(如 graceful)或在测试中使用, 此时应用需要在退出前调用 Sync
+func StopSignalHandler() {
+	signalMu.Lock()
+	defer signalMu.Unlock()
+	if exitSignal == nil {
+		return
+	}
+	signal.Stop(exitSignal)
+	close(exitSignal)
+	exitSignal = nil
+}
+
+// Sync flushes buffer, 停止退出信号处理后应用需要在退出前调用
+func Sync() error {
+	return zapLogger.Sync()
 }
 
 type Options struct {
@@ -93,6 +201,11 @@ type Options struct {
 	level      zapcore.Level
 	callerSkip int
 	fileLog    *FileLogger
+	sinks      []SinkConfig
+	redact     RedactConfig
+	sampling   *SamplingConfig
+	rateLimit  *RateLimitConfig
+	noSignal   bool
 }
 
 type Option func(*Options)
@@ -127,7 +240,16 @@ func FileLog(fileLog *FileLogger) Option {
 	}
 }
 
+// DisableSignalHandler 停止 init 时注册的退出信号处理, 见 StopSignalHandler
+func DisableSignalHandler() Option {
+	return func(o *Options) {
+		o.noSignal = true
+	}
+}
+
 // SetLogger customize yourself logger.
+// 替换前通过 With, Named 等派生的 logger 仍使用旧的 sink, 旧的 sink 关闭后这些 logger 的日志会被丢弃,
+// 替换后应重新通过 With, Named 获取 logger
 func SetLogger(options ...Option) (err error) {
 	opts := Options{
 		env:        DefaultEnv,
@@ -139,34 +261,55 @@ func SetLogger(options ...Option) (err error) {
 		o(&opts)
 	}
 
+	if opts.noSignal {
+		StopSignalHandler()
+	}
+
+	sinks := opts.sinks
 	if opts.fileLog != nil {
-		core := zapcore.NewCore(
-			zapcore.NewJSONEncoder(zapLoggerEncoderConfig),
-			zapcore.AddSync(&lumberjack.Logger{
-				Filename:   opts.fileLog.Filename,
-				MaxSize:    opts.fileLog.MaxSize,
-				MaxAge:     opts.fileLog.MaxAge,
-				MaxBackups: opts.fileLog.MaxBackups,
-				LocalTime:  opts.fileLog.LocalTime,
-				Compress:   opts.fileLog.Compress,
-			}),
-			zap.NewAtomicLevelAt(opts.level),
-		)
-		zapLogger = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(opts.callerSkip))
+		sinks = append([]SinkConfig{{Type: SinkFile, Encoder: EncoderJson, File: opts.fileLog}}, sinks...)
+	}
+
+	r, err := newRedactor(opts.redact, opts.env)
+	if err != nil {
+		return err
+	}
+
+	prev := zapLogger
+	var closer io.Closer
+	if len(sinks) != 0 {
+		var core zapcore.Core
+		if core, closer, err = newTeeCore(sinks, r); err != nil {
+			return err
+		}
+		redactor, sampling, limiter = r, opts.sampling, newRateLimiter(opts.rateLimit)
+		zapLogger = zap.New(wrapCore(core), zap.AddCaller(), zap.AddCallerSkip(opts.callerSkip))
 	} else {
+		s := opts.sampling
 		if opts.env == LogReleaseEnv {
 			zapLoggerConfig = zap.NewProductionConfig()
 			zapLoggerConfig.EncoderConfig = zapLoggerEncoderConfig
+			if s == nil && zapLoggerConfig.Sampling != nil {
+				s = &SamplingConfig{Initial: zapLoggerConfig.Sampling.Initial, Thereafter: zapLoggerConfig.Sampling.Thereafter}
+			}
 		}
 
-		zapLoggerConfig.Level = zap.NewAtomicLevelAt(opts.level)
-		zapLogger, err = zapLoggerConfig.Build(zap.AddCaller(), zap.AddCallerSkip(opts.callerSkip))
+		redactor, sampling, limiter = r, s, newRateLimiter(opts.rateLimit)
+		zapLogger, err = buildConfig(zap.AddCaller(), zap.AddCallerSkip(opts.callerSkip))
 		if err != nil {
 			return err
 		}
 	}
 
-	log = zapLogger.Sugar()
+	levels.global.SetLevel(opts.level)
+	log = newZapLog(zapLogger)
+
+	// 关闭被替换的 logger 使用的文件、连接等, 会等待正在进行的写入完成
+	_ = prev.Sync()
+	if sinkCloser != nil {
+		_ = sinkCloser.Close()
+	}
+	sinkCloser = closer
 	return nil
 }
 
@@ -180,10 +323,10 @@ func GetLogger() Logger {
 func SetLoggerCallerDisable() (err error) {
 	zapLoggerConfig.Development = false
 	zapLoggerConfig.DisableCaller = true
-	zapLogger, err = zapLoggerConfig.Build()
+	zapLogger, err = buildConfig()
 	if err != nil {
 		return err
 	}
-	log = zapLogger.Sugar()
+	log = newZapLog(zapLogger)
 	return nil
 }
@@ -7,7 +7,6 @@ import (
 import (
 	"gorm.io/driver/clickhouse"
 	"gorm.io/gorm"
-	gormLogger "gorm.io/gorm/logger"
 	"gorm.io/gorm/schema"
 )
 
@@ -22,6 +21,7 @@ func (g *GormDB) InitCK(options ...Option) error {
 		maxOpenConn:     DefaultMaxOpenConn,
 		maxIdleConn:     DefaultMaxIdleConn,
 		connMaxLifetime: DefaultConnMaxLifetime,
+		slowThresholdMs: DefaultSlowThresholdMs,
 	}
 
 	for _, o := range options {
@@ -34,7 +34,7 @@ func (g *GormDB) InitCK(options ...Option) error {
 		NamingStrategy: schema.NamingStrategy{
 			SingularTable: true,
 		},
-		Logger: gormLogger.Default.LogMode(opts.logLevel),
+		Logger: NewLogger(opts.logLevel, time.Duration(opts.slowThresholdMs)*time.Millisecond),
 	})
 	if err != nil {
 		logger.Errorf("gorm.Open dsn:%s err:%s", opts.dsn, err.Error())
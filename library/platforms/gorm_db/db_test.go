@@ -1,8 +1,19 @@
 package gorm_db
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+)
+
+import (
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
 )
 
 import (
@@ -23,3 +34,33 @@ func TestCK(t *testing.T) {
 	ck.DB.Raw("select version()").Take(&ver)
 	t.Log(ver)
 }
+
+func TestLogger(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "app.log")
+	if err := logger.SetLogger(logger.Sinks(logger.SinkConfig{Type: logger.SinkFile, File: &logger.FileLogger{Filename: file}})); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = logger.SetLogger() }()
+
+	ctx := logger.ContextWithTraceId(context.Background(), "trace-1")
+	l := NewLogger(gormLogger.Warn, time.Millisecond*100)
+	fc := func() (string, int64) { return "select * from user", 2 }
+	l.Trace(ctx, time.Now(), fc, nil)
+	l.Trace(ctx, time.Now(), fc, gorm.ErrRecordNotFound)
+	l.Trace(ctx, time.Now().Add(-time.Second), fc, nil)
+	l.Trace(ctx, time.Now(), fc, errors.New("bad connection"))
+	l.LogMode(gormLogger.Info).Trace(ctx, time.Now(), fc, nil)
+
+	data, _ := ioutil.ReadFile(file)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"message":"gorm slow sql"`) ||
+		!strings.Contains(lines[1], `"error":"bad connection"`) || !strings.Contains(lines[2], `"rows":2`) {
+		t.Fatalf("unexpected output:%s", data)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, `"logger":"gorm"`) || !strings.Contains(line, `"traceId":"trace-1"`) ||
+			!strings.Contains(line, `"sql":"select * from user"`) {
+			t.Fatalf("unexpected line:%s", line)
+		}
+	}
+}
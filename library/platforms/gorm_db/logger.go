@@ -0,0 +1,89 @@
+package gorm_db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+import (
+	"gorm.io/gorm"
+	gormLogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+// LoggerName gorm 日志使用的 logger 名称, 可以通过 logger.SetNamedLevel 单独修改级别
+const LoggerName = "gorm"
+
+// gormLog 将 gorm 的日志输出到 common/logger, 日志包含 ctx 中的 trace id, request id
+type gormLog struct {
+	level         gormLogger.LogLevel
+	slowThreshold time.Duration
+}
+
+// NewLogger 创建 gorm 的 logger, 执行时间超过 slowThreshold 的 sql 以 warn 级别输出, slowThreshold 为 0 时不检查慢查询,
+// level 为 gormLogger.Info 时输出全部 sql
+func NewLogger(level gormLogger.LogLevel, slowThreshold time.Duration) gormLogger.Interface {
+	return &gormLog{level: level, slowThreshold: slowThreshold}
+}
+
+func (l *gormLog) log(ctx context.Context) logger.FieldLogger {
+	return logger.Named(LoggerName).WithContext(ctx)
+}
+
+func (l *gormLog) LogMode(level gormLogger.LogLevel) gormLogger.Interface {
+	n := *l
+	n.level = level
+	return &n
+}
+
+func (l *gormLog) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormLogger.Info {
+		l.log(ctx).Infow(fmt.Sprintf(msg, data...), logger.String("source", utils.FileWithLineNum()))
+	}
+}
+
+func (l *gormLog) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormLogger.Warn {
+		l.log(ctx).Warnw(fmt.Sprintf(msg, data...), logger.String("source", utils.FileWithLineNum()))
+	}
+}
+
+func (l *gormLog) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= gormLogger.Error {
+		l.log(ctx).Errorw(fmt.Sprintf(msg, data...), logger.String("source", utils.FileWithLineNum()))
+	}
+}
+
+// Trace 输出 sql, 影响行数(未知时为 -1)及执行时间, 查询不到记录(gorm.ErrRecordNotFound)不作为错误
+func (l *gormLog) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= gormLogger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	fields := func(sql string, rows int64) []interface{} {
+		return []interface{}{
+			logger.String("sql", sql),
+			logger.Int64("rows", rows),
+			logger.Duration("elapsed", elapsed),
+			logger.String("source", utils.FileWithLineNum()),
+		}
+	}
+	switch {
+	case err != nil && l.level >= gormLogger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		sql, rows := fc()
+		l.log(ctx).Errorw("gorm sql failed", append(fields(sql, rows), logger.Err(err))...)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= gormLogger.Warn:
+		sql, rows := fc()
+		l.log(ctx).Warnw("gorm slow sql", append(fields(sql, rows), logger.Duration("threshold", l.slowThreshold))...)
+	case l.level >= gormLogger.Info:
+		sql, rows := fc()
+		l.log(ctx).Infow("gorm sql", fields(sql, rows)...)
+	}
+}
@@ -1,6 +1,7 @@
 package gorm_db
 
 import (
+	"context"
 	"strings"
 )
 
@@ -13,6 +14,15 @@ type GormDB struct {
 	DB *gorm.DB
 }
 
+// Check ping 数据库, 可作为 graceful 的就绪检查
+func (g *GormDB) Check(ctx context.Context) error {
+	db, err := g.DB.DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
 const (
 	SilentLogLevel = "silent"
 	ErrorLogLevel  = "error"
@@ -26,6 +36,7 @@ type Options struct {
 	maxOpenConn     int
 	maxIdleConn     int
 	connMaxLifetime int
+	slowThresholdMs int
 }
 
 type Option func(*Options)
@@ -38,6 +49,8 @@ const (
 	DefaultMaxOpenConn     = 1000
 	DefaultMaxIdleConn     = 100
 	DefaultConnMaxLifetime = 3600
+	// DefaultSlowThresholdMs 执行时间超过该值的 sql 以 warn 级别输出
+	DefaultSlowThresholdMs = 200
 )
 
 func LogLevel(logLevel string) Option {
@@ -80,3 +93,10 @@ func ConnMaxLifetime(connMaxLifetime int) Option {
 		o.connMaxLifetime = connMaxLifetime
 	}
 }
+
+// SlowThresholdMs 慢查询阈值, 为 0 时不检查慢查询
+func SlowThresholdMs(slowThresholdMs int) Option {
+	return func(o *Options) {
+		o.slowThresholdMs = slowThresholdMs
+	}
+}
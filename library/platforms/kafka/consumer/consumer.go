@@ -1,15 +1,18 @@
 package consumer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 import (
 	"github.com/lethexixin/go-funcs/common/logger"
+	"github.com/lethexixin/go-funcs/library/platforms/kafka/kafka_log"
 )
 
 import (
@@ -21,6 +24,22 @@ type KafkaConsumer struct {
 	Consumer *kafka.Consumer
 }
 
+// Check 获取 kafka 集群元数据, 可作为 graceful 的就绪检查, ctx 已结束时直接返回 ctx.Err()
+func (k *KafkaConsumer) Check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeoutMs := 3000
+	if deadline, ok := ctx.Deadline(); ok {
+		// librdkafka 的超时为负数时会一直阻塞, 至少等待 1ms
+		if timeoutMs = int(time.Until(deadline).Milliseconds()); timeoutMs < 1 {
+			timeoutMs = 1
+		}
+	}
+	_, err := k.Consumer.GetMetadata(nil, false, timeoutMs)
+	return err
+}
+
 var counterMetric *prometheus.CounterVec
 
 func InitMetrics(appName string) {
@@ -231,6 +250,7 @@ func (k *KafkaConsumer) InitConsumer(options ...Option) (err error) {
 	default:
 		return fmt.Errorf("unknown kafka protocol:%s", opts.securityProtocol)
 	}
+	kafka_log.EnableLogs(kafkaConf)
 
 	k.Consumer, err = kafka.NewConsumer(kafkaConf)
 	if err != nil {
@@ -240,6 +260,7 @@ func (k *KafkaConsumer) InitConsumer(options ...Option) (err error) {
 	defer k.Consumer.Close()
 
 	logger.Info("create kafka consumer successful")
+	go kafka_log.Forward(k.Consumer.Logs())
 
 	err = k.Consumer.SubscribeTopics(opts.subscribeTopics, nil)
 	if err != nil {
@@ -257,7 +278,7 @@ func (k *KafkaConsumer) InitConsumer(options ...Option) (err error) {
 			msg, err := k.Consumer.ReadMessage(-1)
 			if err != nil {
 				// The client will automatically try to recover from all errors.
-				logger.Errorf("consumer read msg err:%s", err.Error())
+				logger.With(logger.RateKey("kafka.consumer.read")).Errorf("consumer read msg err:%s", err.Error())
 				if counterMetric != nil {
 					counterMetric.With(prometheus.Labels{"topic": strings.Join(opts.subscribeTopics, ","), "flag": "error"}).Inc()
 				}
@@ -0,0 +1,43 @@
+package kafka_log
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// LoggerName librdkafka 日志使用的 logger 名称, 可以通过 logger.SetNamedLevel 单独修改级别
+const LoggerName = "kafka"
+
+// EnableLogs 开启 librdkafka 的日志转发, 创建 Producer, Consumer 后将 Logs() 传给 Forward
+func EnableLogs(conf *kafka.ConfigMap) {
+	_ = conf.SetKey("go.logs.channel.enable", true)
+}
+
+// Forward 将 librdkafka 的日志输出到 common/logger, 直到 logs 被关闭(Producer, Consumer Close 时)
+func Forward(logs chan kafka.LogEvent) {
+	if logs == nil {
+		return
+	}
+	for ev := range logs {
+		Log(ev)
+	}
+}
+
+// Log 按 syslog 级别输出一条 librdkafka 日志: 0-3 为 error, 4 为 warn, 5-6 为 info, 7 为 debug
+func Log(ev kafka.LogEvent) {
+	log := logger.Named(LoggerName)
+	fields := []interface{}{logger.String("client", ev.Name), logger.String("tag", ev.Tag)}
+	switch {
+	case ev.Level <= 3:
+		log.Errorw(ev.Message, fields...)
+	case ev.Level == 4:
+		log.Warnw(ev.Message, fields...)
+	case ev.Level <= 6:
+		log.Infow(ev.Message, fields...)
+	default:
+		log.Debugw(ev.Message, fields...)
+	}
+}
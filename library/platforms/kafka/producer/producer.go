@@ -1,6 +1,7 @@
 package producer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -12,6 +13,7 @@ import (
 
 import (
 	"github.com/lethexixin/go-funcs/common/logger"
+	"github.com/lethexixin/go-funcs/library/platforms/kafka/kafka_log"
 )
 
 import (
@@ -23,6 +25,22 @@ type KafkaProducer struct {
 	Producer *kafka.Producer
 }
 
+// Check 获取 kafka 集群元数据, 可作为 graceful 的就绪检查, ctx 已结束时直接返回 ctx.Err()
+func (k *KafkaProducer) Check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeoutMs := 3000
+	if deadline, ok := ctx.Deadline(); ok {
+		// librdkafka 的超时为负数时会一直阻塞, 至少等待 1ms
+		if timeoutMs = int(time.Until(deadline).Milliseconds()); timeoutMs < 1 {
+			timeoutMs = 1
+		}
+	}
+	_, err := k.Producer.GetMetadata(nil, false, timeoutMs)
+	return err
+}
+
 var counterMetric *prometheus.CounterVec
 
 func InitMetrics(appName string) {
@@ -248,6 +266,7 @@ func (k *KafkaProducer) InitProducer(fn func(), options ...Option) (err error) {
 	default:
 		return fmt.Errorf("unknown kafka protocol:%s", opts.securityProtocol)
 	}
+	kafka_log.EnableLogs(kafkaConf)
 
 	k.Producer, err = kafka.NewProducer(kafkaConf)
 	if err != nil {
@@ -257,6 +276,7 @@ func (k *KafkaProducer) InitProducer(fn func(), options ...Option) (err error) {
 	defer k.Producer.Close()
 
 	logger.Info("create kafka producer successful")
+	go kafka_log.Forward(k.Producer.Logs())
 
 	// Listen to all the events on the default events channel
 	go func() {
@@ -8,6 +8,7 @@ import (
 
 import (
 	"github.com/lethexixin/go-funcs/common/graceful"
+	"github.com/lethexixin/go-funcs/common/logger"
 )
 
 import (
@@ -50,6 +51,46 @@ func NewHistogram(name, help string, labelName []string, buckets []float64) *pro
 	)
 }
 
+// NewInFlightGauge 处理中的请求数, 见 graceful.Manager.Track, m 为 nil 时使用 graceful.Default(), 如:
+//
+//	prometheus.MustRegister(NewInFlightGauge("http_inflight_requests", "in-flight requests", nil))
+func NewInFlightGauge(name, help string, m *graceful.Manager) prometheus.GaugeFunc {
+	if m == nil {
+		m = graceful.Default()
+	}
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		func() float64 {
+			return float64(m.InFlight())
+		},
+	)
+}
+
+// logDroppedCollector 导出 logger.Dropped 的丢弃条数
+type logDroppedCollector struct {
+	desc *prometheus.Desc
+}
+
+// NewLogDroppedCollector 被采样与限流丢弃的日志条数, 见 logger.Sampling, logger.RateLimit, 标签为 reason, level, 如:
+//
+//	prometheus.MustRegister(NewLogDroppedCollector("log_dropped_total", "dropped log lines"))
+func NewLogDroppedCollector(name, help string) prometheus.Collector {
+	return &logDroppedCollector{desc: prometheus.NewDesc(name, help, []string{"reason", "level"}, nil)}
+}
+
+func (c *logDroppedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *logDroppedCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, d := range logger.Dropped() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(d.Count), d.Reason, d.Level.String())
+	}
+}
+
 func CollectMetricsHistogram(dataHisChan chan MetricHisData, metric *prometheus.HistogramVec) {
 	signChan := make(chan os.Signal, 1)
 	signal.Notify(signChan, graceful.ShutdownSignals...)
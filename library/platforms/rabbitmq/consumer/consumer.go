@@ -1,6 +1,7 @@
 package consumer
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -19,6 +20,14 @@ type MQConsumer struct {
 	Consumer *consumer
 }
 
+// Check 检查 rabbitmq 连接状态, 可作为 graceful 的就绪检查
+func (c *MQConsumer) Check(context.Context) error {
+	if c.Consumer == nil || c.Consumer.conn == nil || c.Consumer.conn.IsClosed() {
+		return errors.New("not connected to a server")
+	}
+	return nil
+}
+
 type Options struct {
 	addr        string // MQ地址
 	virtualHost string // 虚拟主机名称
@@ -19,6 +19,14 @@ type MQProducer struct {
 	Producer *producer
 }
 
+// Check 检查 rabbitmq 连接状态, 可作为 graceful 的就绪检查
+func (p *MQProducer) Check(context.Context) error {
+	if p.Producer == nil || !p.Producer.isReady || p.Producer.connection == nil || p.Producer.connection.IsClosed() {
+		return errNotConnected
+	}
+	return nil
+}
+
 type producer struct {
 	opts            *Options
 	connection      *amqp.Connection
@@ -150,7 +158,7 @@ func (p *producer) handleReconnect() {
 
 		conn, err := p.connect()
 		if err != nil {
-			logger.Errorf("failed to connect %s, err:%s. retrying...", p.opts.queueName, err.Error())
+			logger.With(logger.RateKey("rabbitmq.producer.connect")).Errorf("failed to connect %s, err:%s. retrying...", p.opts.queueName, err.Error())
 			select {
 			case <-p.done:
 				return
@@ -189,7 +197,7 @@ func (p *producer) handleReInit(conn *amqp.Connection) bool {
 		p.isReady = false
 		err := p.init(conn)
 		if err != nil {
-			logger.Errorf("failed to init channel %s, err:%. retrying...", p.opts.queueName, err.Error())
+			logger.With(logger.RateKey("rabbitmq.producer.init")).Errorf("failed to init channel %s, err:%. retrying...", p.opts.queueName, err.Error())
 			select {
 			case <-p.done:
 				return true
@@ -301,7 +309,7 @@ func (p *producer) Push(data []byte, priority uint8) error {
 	for {
 		err := p.UnsafePush(data, priority)
 		if err != nil {
-			logger.Errorf("push data failed, err:%s. retrying...", err.Error())
+			logger.With(logger.RateKey("rabbitmq.producer.push")).Errorf("push data failed, err:%s. retrying...", err.Error())
 			select {
 			case <-p.done:
 				return errShutdown
@@ -316,7 +324,7 @@ func (p *producer) Push(data []byte, priority uint8) error {
 			}
 		case <-time.After(resendDelay):
 		}
-		logger.Info("push didn't confirm. retrying...")
+		logger.With(logger.RateKey("rabbitmq.producer.confirm")).Info("push didn't confirm. retrying...")
 	}
 }
 
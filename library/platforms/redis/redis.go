@@ -147,3 +147,8 @@ func (r *Redis) Init(options ...Option) (err error) {
 
 	return nil
 }
+
+// Check ping redis, 可作为 graceful 的就绪检查
+func (r *Redis) Check(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
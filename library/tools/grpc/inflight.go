@@ -0,0 +1,49 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/graceful"
+)
+
+import (
+	"google.golang.org/grpc"
+)
+
+// healthMethodPrefix 健康检查的 Watch 是长连接, 不计入处理中的请求
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// InFlightUnaryInterceptor 统计处理中的一元请求数, m 为 nil 时使用 graceful.Default(), 如:
+//
+//	NewServer(grpc.ChainUnaryInterceptor(InFlightUnaryInterceptor(nil)), grpc.ChainStreamInterceptor(InFlightStreamInterceptor(nil)))
+func InFlightUnaryInterceptor(m *graceful.Manager) grpc.UnaryServerInterceptor {
+	if m == nil {
+		m = graceful.Default()
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(ctx, req)
+		}
+		done := m.Track()
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// InFlightStreamInterceptor 统计处理中的流式请求数, m 为 nil 时使用 graceful.Default()
+func InFlightStreamInterceptor(m *graceful.Manager) grpc.StreamServerInterceptor {
+	if m == nil {
+		m = graceful.Default()
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, healthMethodPrefix) {
+			return handler(srv, ss)
+		}
+		done := m.Track()
+		defer done()
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,92 @@
+package grpc
+
+import (
+	"fmt"
+	"strings"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"google.golang.org/grpc/grpclog"
+)
+
+// LoggerName grpc 内部日志使用的 logger 名称, 可以通过 logger.SetNamedLevel 单独修改级别
+const LoggerName = "grpc"
+
+// grpcLog 将 grpc 内部的日志(grpclog)输出到 common/logger
+type grpcLog struct {
+	verbosity int
+}
+
+// NewLogger 创建 grpclog.LoggerV2, verbosity 见 grpclog.LoggerV2.V, 通常为 0
+func NewLogger(verbosity int) grpclog.LoggerV2 {
+	return &grpcLog{verbosity: verbosity}
+}
+
+// SetLogger 将 grpc 内部的日志输出到 common/logger, 需要在创建 grpc server, client 之前调用
+func SetLogger(verbosity int) {
+	grpclog.SetLoggerV2(NewLogger(verbosity))
+}
+
+func (g *grpcLog) log() logger.FieldLogger {
+	return logger.Named(LoggerName)
+}
+
+func sprintln(args []interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(args...), "\n")
+}
+
+func (g *grpcLog) Info(args ...interface{}) {
+	g.log().Info(args...)
+}
+
+func (g *grpcLog) Infoln(args ...interface{}) {
+	g.log().Info(sprintln(args))
+}
+
+func (g *grpcLog) Infof(format string, args ...interface{}) {
+	g.log().Infof(format, args...)
+}
+
+func (g *grpcLog) Warning(args ...interface{}) {
+	g.log().Warn(args...)
+}
+
+func (g *grpcLog) Warningln(args ...interface{}) {
+	g.log().Warn(sprintln(args))
+}
+
+func (g *grpcLog) Warningf(format string, args ...interface{}) {
+	g.log().Warnf(format, args...)
+}
+
+func (g *grpcLog) Error(args ...interface{}) {
+	g.log().Error(args...)
+}
+
+func (g *grpcLog) Errorln(args ...interface{}) {
+	g.log().Error(sprintln(args))
+}
+
+func (g *grpcLog) Errorf(format string, args ...interface{}) {
+	g.log().Errorf(format, args...)
+}
+
+func (g *grpcLog) Fatal(args ...interface{}) {
+	g.log().Fatal(args...)
+}
+
+func (g *grpcLog) Fatalln(args ...interface{}) {
+	g.log().Fatal(sprintln(args))
+}
+
+func (g *grpcLog) Fatalf(format string, args ...interface{}) {
+	g.log().Fatalf(format, args...)
+}
+
+func (g *grpcLog) V(l int) bool {
+	return l <= g.verbosity
+}
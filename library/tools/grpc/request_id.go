@@ -0,0 +1,70 @@
+package grpc
+
+import (
+	"context"
+)
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	MetadataRequestId   = "x-request-id"
+	MetadataTraceId     = "x-trace-id"
+	MetadataTraceParent = "traceparent"
+)
+
+// RequestIdUnaryInterceptor 从 metadata 获取 request id(没有时生成)与 trace id 并保存到 ctx 中,
+// 之后可以使用 logger.WithContext(ctx) 输出带有 requestId, traceId 的日志
+func RequestIdUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(requestIdContext(ctx), req)
+	}
+}
+
+// RequestIdStreamInterceptor 同 RequestIdUnaryInterceptor, 用于流式请求
+func RequestIdStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: requestIdContext(ss.Context())})
+	}
+}
+
+func requestIdContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) != 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	requestId := first(MetadataRequestId)
+	if len(requestId) == 0 {
+		requestId = logger.NewRequestId()
+	}
+	ctx = logger.ContextWithRequestId(ctx, requestId)
+
+	traceId := logger.ParseTraceParent(first(MetadataTraceParent))
+	if len(traceId) == 0 {
+		traceId = first(MetadataTraceId)
+	}
+	if len(traceId) != 0 {
+		ctx = logger.ContextWithTraceId(ctx, traceId)
+	}
+	return ctx
+}
+
+// contextStream 替换 ServerStream 的 ctx
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -1,16 +1,19 @@
 package grpc
 
 import (
-	"net"
+	"context"
 	"runtime"
 )
 
 import (
+	"github.com/lethexixin/go-funcs/common/graceful"
 	"github.com/lethexixin/go-funcs/common/logger"
 )
 
 import (
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -20,11 +23,40 @@ const (
 
 type Server struct {
 	Server *grpc.Server
+	// Health gRPC 健康检查服务, Shutdown 时置为 NOT_SERVING
+	Health *health.Server
 }
 
-func NewServer() *Server {
-	return &Server{
-		Server: grpc.NewServer(),
+func NewServer(opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		Server: grpc.NewServer(opts...),
+		Health: health.NewServer(),
+	}
+	grpc_health_v1.RegisterHealthServer(s.Server, s.Health)
+	return s
+}
+
+// Shutdown 将健康检查置为 NOT_SERVING, 然后 GracefulStop 等待处理中的请求完成,
+// ctx 结束时仍未完成则调用 Stop 强制关闭, 可以通过 graceful.SetGrpcSrvInfo 在程序退出时自动调用
+func (gs *Server) Shutdown(ctx context.Context) error {
+	if gs.Health != nil {
+		gs.Health.Shutdown()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		gs.Server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		logger.Warnf("grpc server graceful stop timeout, stop immediately")
+		gs.Server.Stop()
+		<-done
+		return ctx.Err()
 	}
 }
 
@@ -32,8 +64,8 @@ type ResisterCallBack func() (s *Server)
 
 func (gs *Server) Service(name string, port string, registerFunc ResisterCallBack) {
 	runtime.GOMAXPROCS(runtime.NumCPU())
-	//起服务
-	lis, err := net.Listen(network, ":"+port)
+	//起服务, 使用 graceful.Listener 以便升级时将监听 socket 交给新进程
+	lis, err := graceful.Listener(network, ":"+port)
 	if err != nil {
 		logger.Errorf("failed to listen %s, err:%s", name, err.Error())
 		return
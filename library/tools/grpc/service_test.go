@@ -0,0 +1,54 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestServerShutdown(t *testing.T) {
+	lis, err := net.Listen(network, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	go func() { _ = s.Server.Serve(lis) }()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// 未结束的 Watch 流会阻塞 GracefulStop
+	stream, err := grpc_health_v1.NewHealthClient(conn).Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := stream.Recv(); err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("expect SERVING, got:%v, err:%v", resp, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.Shutdown(ctx) }()
+
+	if resp, err := stream.Recv(); err != nil || resp.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expect NOT_SERVING, got:%v, err:%v", resp, err)
+	}
+	select {
+	case err = <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expect fallback to Stop after deadline, got:%v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("wait shutdown timeout")
+	}
+}
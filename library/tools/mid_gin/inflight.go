@@ -0,0 +1,22 @@
+package mid_gin
+
+import (
+	"github.com/lethexixin/go-funcs/common/graceful"
+)
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// InFlight 统计处理中的请求数, 程序退出时 graceful 会等待处理中的请求完成后再关闭下游客户端,
+// m 为 nil 时使用 graceful.Default()
+func InFlight(m *graceful.Manager) gin.HandlerFunc {
+	if m == nil {
+		m = graceful.Default()
+	}
+	return func(c *gin.Context) {
+		done := m.Track()
+		defer done()
+		c.Next()
+	}
+}
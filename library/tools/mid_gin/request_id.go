@@ -0,0 +1,40 @@
+package mid_gin
+
+import (
+	"github.com/lethexixin/go-funcs/common/logger"
+)
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HeaderRequestId   = "X-Request-Id"
+	HeaderTraceId     = "X-Trace-Id"
+	HeaderTraceParent = "traceparent"
+)
+
+// RequestId 从请求头获取 request id(没有时生成)与 trace id, 保存到 c 与 c.Request 的 ctx 中, 并通过响应头返回 request id,
+// 之后可以使用 logger.WithContext(c) 或 logger.WithContext(c.Request.Context()) 输出带有 requestId, traceId 的日志
+func RequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(HeaderRequestId)
+		if len(requestId) == 0 {
+			requestId = logger.NewRequestId()
+		}
+		traceId := logger.ParseTraceParent(c.GetHeader(HeaderTraceParent))
+		if len(traceId) == 0 {
+			traceId = c.GetHeader(HeaderTraceId)
+		}
+
+		ctx := logger.ContextWithRequestId(c.Request.Context(), requestId)
+		c.Set(logger.RequestIdKey, requestId)
+		if len(traceId) != 0 {
+			ctx = logger.ContextWithTraceId(ctx, traceId)
+			c.Set(logger.TraceIdKey, traceId)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(HeaderRequestId, requestId)
+		c.Next()
+	}
+}